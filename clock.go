@@ -0,0 +1,28 @@
+package main
+
+import "time"
+
+// Clock is the time source of the Snowflake, it can be replaced to simulate
+// the clock moving backwards.
+type Clock interface {
+	// UnixMilli returns the current unix timestamp in milliseconds.
+	UnixMilli() int64
+	// Now returns the current time, the monotonic reading measures how long the Snowflake has waited.
+	Now() time.Time
+	// Sleep pauses the current goroutine for at least the duration d.
+	Sleep(d time.Duration)
+}
+
+type systemClock struct{}
+
+func (systemClock) UnixMilli() int64 {
+	return time.Now().UnixMilli()
+}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/concurrency"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var etcdProvider *EtcdProvider
+
+func getEtcdProvider(endpoints []string, keyPrefix string, leaseTTL int, hintWorkerId int64, maxWorkerId int64, enableSelfPreservation bool, safeSelfPreservation bool) *EtcdProvider {
+	once.Do(func() {
+		c, err := clientv3.New(clientv3.Config{
+			Endpoints:   endpoints,
+			DialTimeout: 5 * time.Second,
+		})
+		if err != nil {
+			log.Fatalf("New etcd client error: %v", err)
+		}
+		etcdProvider = newEtcdProvider(c, keyPrefix, leaseTTL, hintWorkerId, maxWorkerId, enableSelfPreservation, safeSelfPreservation)
+	})
+	return etcdProvider
+}
+
+// newEtcdProvider creates an EtcdProvider with the given client and starts acquiring a worker id,
+// the client can point to an embedded etcd server.
+func newEtcdProvider(c *clientv3.Client, keyPrefix string, leaseTTL int, hintWorkerId int64, maxWorkerId int64, enableSelfPreservation bool, safeSelfPreservation bool) *EtcdProvider {
+	p := &EtcdProvider{
+		keyPrefix: keyPrefix,
+		leaseTTL:  leaseTTL,
+		etcd:      c,
+	}
+	p.init("etcd", hintWorkerId, maxWorkerId, enableSelfPreservation, safeSelfPreservation)
+	go p.acquire(p.lock, p.release)
+	return p
+}
+
+// EtcdProvider acquires a worker id by locking the key prefix + worker id with a
+// concurrency.Mutex, the lock is bound to a session lease which is kept alive by the client.
+type EtcdProvider struct {
+	lockHolder
+	sync.Mutex
+	session   *concurrency.Session
+	mutex     *concurrency.Mutex
+	keyPrefix string
+	leaseTTL  int
+	etcd      *clientv3.Client
+}
+
+// lock tries once to lock the worker id with a new session, the session is done once the lease expired
+// or the keep alive gave up. Others may hold the mutex already since there is no lock delay in etcd, so
+// there is no grace period for the safe self preservation.
+func (p *EtcdProvider) lock(workerId int64) (<-chan struct{}, time.Duration, error) {
+	session, err := concurrency.NewSession(p.etcd, concurrency.WithTTL(p.leaseTTL))
+	if err != nil {
+		return nil, 0, fmt.Errorf("new session error: %w", err)
+	}
+	mutex := concurrency.NewMutex(session, p.keyPrefix+strconv.FormatInt(workerId, 10))
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	if err := mutex.TryLock(ctx); err != nil {
+		session.Close()
+		return nil, 0, err
+	}
+	p.Lock()
+	p.session = session
+	p.mutex = mutex
+	p.Unlock()
+	return session.Done(), 0, nil
+}
+
+// release unlocks the worker id and revokes the session lease if there is one
+func (p *EtcdProvider) release() {
+	p.Lock()
+	defer p.Unlock()
+	if p.session == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	if err := p.mutex.Unlock(ctx); err != nil {
+		log.Printf("unlock worker id error %v", err)
+	}
+	if err := p.session.Close(); err != nil {
+		log.Printf("close etcd session error %v", err)
+	}
+	p.session = nil
+	p.mutex = nil
+}
+
+func (p *EtcdProvider) Stop() {
+	log.Printf("EtcdProvider stop, release worker id: %d", p.workerId.Load().(int64))
+	close(p.stopCh)
+	defer p.publish(ProviderStopped, p.workerId.Load().(int64))
+	p.release()
+	if err := p.etcd.Close(); err != nil {
+		log.Println("close etcd client err: ", err)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/server/v3/embed"
+)
+
+// freeURL returns a local http url whose port is free right now
+func freeURL(t *testing.T) url.URL {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen error %v", err)
+	}
+	defer l.Close()
+	return url.URL{Scheme: "http", Host: l.Addr().String()}
+}
+
+// startEtcd starts an embedded single node etcd server and returns its client url
+func startEtcd(t *testing.T) string {
+	t.Helper()
+	cfg := embed.NewConfig()
+	cfg.Dir = t.TempDir()
+	cfg.LogLevel = "error"
+	peerURL, clientURL := freeURL(t), freeURL(t)
+	cfg.LPUrls, cfg.APUrls = []url.URL{peerURL}, []url.URL{peerURL}
+	cfg.LCUrls, cfg.ACUrls = []url.URL{clientURL}, []url.URL{clientURL}
+	cfg.InitialCluster = cfg.InitialClusterFromName(cfg.Name)
+	e, err := embed.StartEtcd(cfg)
+	if err != nil {
+		t.Fatalf("start etcd error %v", err)
+	}
+	t.Cleanup(e.Close)
+	select {
+	case <-e.Server.ReadyNotify():
+	case <-time.After(providerTimeout):
+		t.Fatal("etcd is not ready")
+	}
+	return clientURL.String()
+}
+
+func newEtcdClient(t *testing.T, endpoint string) *clientv3.Client {
+	t.Helper()
+	c, err := clientv3.New(clientv3.Config{Endpoints: []string{endpoint}, DialTimeout: 5 * time.Second})
+	if err != nil {
+		t.Fatalf("new etcd client error %v", err)
+	}
+	return c
+}
+
+func TestEtcdProvider(t *testing.T) {
+	endpoint := startEtcd(t)
+	admin := newEtcdClient(t, endpoint)
+	defer admin.Close()
+	const keyPrefix = "snowflake/test/"
+	// otherKey queues behind the mutex key of the owner, it holds the worker id once the owner is gone
+	otherKey := func(workerId int64) string {
+		return keyPrefix + strconv.FormatInt(workerId, 10) + "/other"
+	}
+
+	testAcquireLoseReacquire(t,
+		func() Provider {
+			return newEtcdProvider(newEtcdClient(t, endpoint), keyPrefix, 5, 0, 1, false, false)
+		},
+		func(workerId int64) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			resp, err := admin.Get(ctx, keyPrefix+strconv.FormatInt(workerId, 10)+"/", clientv3.WithPrefix())
+			if err != nil || len(resp.Kvs) == 0 {
+				t.Fatalf("get the mutex key of worker id %d error %v", workerId, err)
+			}
+			if _, err := admin.Put(ctx, otherKey(workerId), ""); err != nil {
+				t.Fatalf("put the other key of worker id %d error %v", workerId, err)
+			}
+			// revoking the lease of the mutex key ends the session of the owner
+			if _, err := admin.Revoke(ctx, clientv3.LeaseID(resp.Kvs[0].Lease)); err != nil {
+				t.Fatalf("revoke the lease of worker id %d error %v", workerId, err)
+			}
+		},
+		func(workerId int64) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if _, err := admin.Delete(ctx, otherKey(workerId)); err != nil {
+				t.Fatalf("delete the other key of worker id %d error %v", workerId, err)
+			}
+		},
+	)
+}
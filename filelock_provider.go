@@ -0,0 +1,79 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+)
+
+var fileLockProvider *FileLockProvider
+
+// FileLockProvider acquires a worker id by taking an exclusive flock on <dir>/<worker id>.lock,
+// the lock is held for the lifetime of the process and released by the kernel if the process exits,
+// which is enough to coordinate the instances on a single host.
+type FileLockProvider struct {
+	eventBus
+	workerId int64
+	file     *os.File
+}
+
+func getFileLockProvider(dir string, hintWorkerId int64, maxWorkerId int64) *FileLockProvider {
+	if hintWorkerId < 0 || hintWorkerId > maxWorkerId {
+		log.Printf("hint-worker-id must between 0 and %d, use default 0\n", maxWorkerId)
+		hintWorkerId = 0
+	}
+	once.Do(func() {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			log.Fatalf("Create lock dir %s error: %v", dir, err)
+		}
+		workerId := roundPre(hintWorkerId, maxWorkerId)
+		var i int64
+		for i = 0; i <= maxWorkerId; i++ {
+			workerId = roundNext(workerId, maxWorkerId)
+			file, err := lockFile(filepath.Join(dir, strconv.FormatInt(workerId, 10)+".lock"))
+			if err != nil {
+				log.Printf("accquire worker id %d error %v", workerId, err)
+				continue
+			}
+			log.Printf("accquire worker id success: %d", workerId)
+			fileLockProvider = &FileLockProvider{
+				workerId: workerId,
+				file:     file,
+			}
+			fileLockProvider.publish(ProviderAcquired, workerId)
+			return
+		}
+		log.Fatalf("All worker ids between 0 and %d are locked in %s", maxWorkerId, dir)
+	})
+	return fileLockProvider
+}
+
+// lockFile opens the file and takes an exclusive flock without blocking
+func lockFile(path string) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+func (p *FileLockProvider) GetWorkerId() (int64, error) {
+	return p.workerId, nil
+}
+
+func (p *FileLockProvider) Stop() {
+	log.Printf("FileLockProvider stop, release worker id: %d", p.workerId)
+	defer p.publish(ProviderStopped, p.workerId)
+	if err := syscall.Flock(int(p.file.Fd()), syscall.LOCK_UN); err != nil {
+		log.Println("unlock worker id err: ", err)
+	}
+	if err := p.file.Close(); err != nil {
+		log.Println("close lock file err: ", err)
+	}
+}
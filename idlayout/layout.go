@@ -0,0 +1,128 @@
+// Package idlayout describes the bit layout of the snowflake ids and parses an id into its parts,
+// other services can import it to decode the ids without calling the ParseId rpc.
+package idlayout
+
+import (
+	"fmt"
+	"time"
+)
+
+// layoutBits is the number of bits an id can use, the sign bit is always 0.
+const layoutBits = 63
+
+// maxRollbackBits limits the number of rollback counters the Snowflake has to track.
+const maxRollbackBits = 8
+
+// Layout describes how the bits of an id are allocated, from high to low:
+// timestamp | rollback counter | datacenter id | worker id | sequence
+type Layout struct {
+	TimestampBits    uint // 时间戳占用位数
+	RollbackBits     uint // 时钟回拨计数器所占位数
+	DatacenterIdBits uint // 数据中心id所占位数
+	WorkerIdBits     uint // 机器id所占位数
+	SequenceBits     uint // 序列所占的位数
+}
+
+// DefaultLayout is the layout of the ids generated before the layout was configurable. It has no
+// rollback counter, borrowing on clock rollback is opt-in since the counter takes the bits of the others.
+var DefaultLayout = Layout{
+	TimestampBits:    41,
+	RollbackBits:     0,
+	DatacenterIdBits: 2,
+	WorkerIdBits:     8,
+	SequenceBits:     12,
+}
+
+// Validate checks the bits of the layout sum to 63 and the rollback counter is not too wide
+func (l Layout) Validate() error {
+	if sum := l.TimestampBits + l.RollbackBits + l.DatacenterIdBits + l.WorkerIdBits + l.SequenceBits; sum != layoutBits {
+		return fmt.Errorf("the bits of layout %s sum to %d, must be %d", l, sum, layoutBits)
+	}
+	if l.TimestampBits == 0 {
+		return fmt.Errorf("timestamp bits must be greater than 0")
+	}
+	if l.SequenceBits == 0 {
+		return fmt.Errorf("sequence bits must be greater than 0")
+	}
+	if l.RollbackBits > maxRollbackBits {
+		return fmt.Errorf("rollback bits must between 0 and %d", maxRollbackBits)
+	}
+	return nil
+}
+
+func (l Layout) String() string {
+	return fmt.Sprintf("timestamp=%d,rollback=%d,datacenter=%d,worker=%d,sequence=%d",
+		l.TimestampBits, l.RollbackBits, l.DatacenterIdBits, l.WorkerIdBits, l.SequenceBits)
+}
+
+// MaxTimestamp 时间戳最大值
+func (l Layout) MaxTimestamp() int64 {
+	return maxValue(l.TimestampBits)
+}
+
+// MaxRollback 支持的最大回拨计数器
+func (l Layout) MaxRollback() int64 {
+	return maxValue(l.RollbackBits)
+}
+
+// MaxDatacenterId 支持的最大数据中心id
+func (l Layout) MaxDatacenterId() int64 {
+	return maxValue(l.DatacenterIdBits)
+}
+
+// MaxWorkerId 支持的最大机器id
+func (l Layout) MaxWorkerId() int64 {
+	return maxValue(l.WorkerIdBits)
+}
+
+// SequenceMask 支持的最大序列号
+func (l Layout) SequenceMask() int64 {
+	return maxValue(l.SequenceBits)
+}
+
+// WorkerIdShift 机器id左移位数
+func (l Layout) WorkerIdShift() uint {
+	return l.SequenceBits
+}
+
+// DatacenterIdShift 数据中心id左移位数
+func (l Layout) DatacenterIdShift() uint {
+	return l.WorkerIdShift() + l.WorkerIdBits
+}
+
+// RollbackShift 回拨计数器左移位数
+func (l Layout) RollbackShift() uint {
+	return l.DatacenterIdShift() + l.DatacenterIdBits
+}
+
+// TimestampShift 时间戳左移位数
+func (l Layout) TimestampShift() uint {
+	return l.RollbackShift() + l.RollbackBits
+}
+
+func maxValue(bits uint) int64 {
+	return int64(-1 ^ (-1 << bits))
+}
+
+// IdInfo 解析id得到的各个组成部分
+type IdInfo struct {
+	Timestamp    int64     // 相对epoch的时间戳，毫秒
+	Time         time.Time // 生成id的时间
+	Rollback     int64     // 时钟回拨计数器
+	DatacenterId int64     // 数据中心机房id
+	WorkerId     int64     // 工作节点
+	Sequence     int64     // 序列号
+}
+
+// ParseId 按照layout和epoch解析id的各个组成部分
+func ParseId(id int64, layout Layout, epoch int64) IdInfo {
+	timestamp := id >> layout.TimestampShift() & layout.MaxTimestamp()
+	return IdInfo{
+		Timestamp:    timestamp,
+		Time:         time.UnixMilli(epoch + timestamp),
+		Rollback:     id >> layout.RollbackShift() & layout.MaxRollback(),
+		DatacenterId: id >> layout.DatacenterIdShift() & layout.MaxDatacenterId(),
+		WorkerId:     id >> layout.WorkerIdShift() & layout.MaxWorkerId(),
+		Sequence:     id & layout.SequenceMask(),
+	}
+}
@@ -0,0 +1,37 @@
+package idlayout
+
+import "testing"
+
+func TestParseId(t *testing.T) {
+	const epoch = int64(1600000000000)
+	l := Layout{TimestampBits: 41, RollbackBits: 1, DatacenterIdBits: 1, WorkerIdBits: 8, SequenceBits: 12}
+	id := int64(12345)<<l.TimestampShift() | 1<<l.RollbackShift() | 1<<l.DatacenterIdShift() | 200<<l.WorkerIdShift() | 4000
+	info := ParseId(id, l, epoch)
+	want := IdInfo{Timestamp: 12345, Rollback: 1, DatacenterId: 1, WorkerId: 200, Sequence: 4000}
+	if info.Timestamp != want.Timestamp || info.Rollback != want.Rollback || info.DatacenterId != want.DatacenterId ||
+		info.WorkerId != want.WorkerId || info.Sequence != want.Sequence {
+		t.Fatalf("ParseId(%d) = %+v, want %+v", id, info, want)
+	}
+	if info.Time.UnixMilli() != epoch+12345 {
+		t.Fatalf("ParseId(%d) time = %s, want %d", id, info.Time, epoch+12345)
+	}
+}
+
+func TestLayoutValidate(t *testing.T) {
+	tests := []struct {
+		layout  Layout
+		wantErr bool
+	}{
+		{DefaultLayout, false},
+		{Layout{TimestampBits: 41, RollbackBits: 0, DatacenterIdBits: 2, WorkerIdBits: 8, SequenceBits: 12}, false},
+		{Layout{TimestampBits: 41, RollbackBits: 1, DatacenterIdBits: 1, WorkerIdBits: 8, SequenceBits: 11}, true},
+		{Layout{TimestampBits: 0, RollbackBits: 1, DatacenterIdBits: 1, WorkerIdBits: 49, SequenceBits: 12}, true},
+		{Layout{TimestampBits: 41, RollbackBits: 1, DatacenterIdBits: 1, WorkerIdBits: 20, SequenceBits: 0}, true},
+		{Layout{TimestampBits: 32, RollbackBits: 9, DatacenterIdBits: 1, WorkerIdBits: 9, SequenceBits: 12}, true},
+	}
+	for _, tt := range tests {
+		if err := tt.layout.Validate(); (err != nil) != tt.wantErr {
+			t.Errorf("%s Validate() = %v, want error %v", tt.layout, err, tt.wantErr)
+		}
+	}
+}
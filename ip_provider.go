@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net"
+)
+
+var ipProvider *IPProvider
+
+// IPProvider derives the worker id from the low worker id bits of the ip address of the host like
+// sonyflake, which is unique as long as the hosts are in a subnet no larger than the worker id range.
+type IPProvider struct {
+	eventBus
+	workerId int64
+}
+
+func (p *IPProvider) Stop() {
+	p.publish(ProviderStopped, p.workerId)
+}
+
+// getIPProvider selects the address by the interface name or the CIDR the address belongs to, the
+// first global unicast address of the up interfaces is used if the selector is empty.
+func getIPProvider(selector string, workerIdBits uint) *IPProvider {
+	once.Do(func() {
+		addrs, err := candidateAddrs(selector)
+		if err != nil {
+			log.Fatalf("Select ip address by %q error: %v", selector, err)
+		}
+		if len(addrs) == 0 {
+			log.Fatalf("No ip address is selected by %q", selector)
+		}
+		addr := addrs[0]
+		workerId := ipWorkerId(addr.IP, workerIdBits)
+		for _, other := range addrs[1:] {
+			if id := ipWorkerId(other.IP, workerIdBits); id != workerId {
+				log.Printf("Warnning: ip address %s is ignored which derives worker id %d, use a more specific ip-selector if it is the expected one", other.IP, id)
+			}
+		}
+		ones, bits := addr.Mask.Size()
+		if hostBits := uint(bits - ones); hostBits > workerIdBits {
+			log.Printf("Warnning: subnet %s has %d host bits but only %d worker id bits, the hosts in the subnet may derive the same worker id",
+				addr, hostBits, workerIdBits)
+		}
+		log.Printf("ip address %s derives worker id %d", addr.IP, workerId)
+		ipProvider = &IPProvider{
+			workerId: workerId,
+		}
+		ipProvider.publish(ProviderAcquired, workerId)
+	})
+	return ipProvider
+}
+
+func (p *IPProvider) GetWorkerId() (int64, error) {
+	return p.workerId, nil
+}
+
+// ipWorkerId returns the low bits of the ip address as the worker id
+func ipWorkerId(ip net.IP, workerIdBits uint) int64 {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	low := binary.BigEndian.Uint64(append(make([]byte, 8), ip...)[len(ip):])
+	return int64(low & (1<<workerIdBits - 1))
+}
+
+// candidateAddrs returns the global unicast addresses selected by the interface name or the CIDR,
+// the ipv4 addresses come first.
+func candidateAddrs(selector string) ([]*net.IPNet, error) {
+	var cidr *net.IPNet
+	var interfaces []net.Interface
+	if _, n, err := net.ParseCIDR(selector); err == nil {
+		cidr = n
+	}
+	if selector != "" && cidr == nil {
+		i, err := net.InterfaceByName(selector)
+		if err != nil {
+			return nil, err
+		}
+		interfaces = []net.Interface{*i}
+	} else {
+		all, err := net.Interfaces()
+		if err != nil {
+			return nil, err
+		}
+		for _, i := range all {
+			if i.Flags&net.FlagUp != 0 && i.Flags&net.FlagLoopback == 0 {
+				interfaces = append(interfaces, i)
+			}
+		}
+	}
+	var addrs []net.Addr
+	for _, i := range interfaces {
+		a, err := i.Addrs()
+		if err != nil {
+			return nil, fmt.Errorf("get addresses of interface %s error: %w", i.Name, err)
+		}
+		addrs = append(addrs, a...)
+	}
+	return selectAddrs(addrs, cidr), nil
+}
+
+// selectAddrs returns the global unicast addresses in the CIDR, or all of them if the CIDR is nil,
+// the ipv4 addresses come first.
+func selectAddrs(addrs []net.Addr, cidr *net.IPNet) []*net.IPNet {
+	var ipv4, ipv6 []*net.IPNet
+	for _, a := range addrs {
+		n, ok := a.(*net.IPNet)
+		if !ok || !n.IP.IsGlobalUnicast() || (cidr != nil && !cidr.Contains(n.IP)) {
+			continue
+		}
+		if n.IP.To4() != nil {
+			ipv4 = append(ipv4, n)
+		} else {
+			ipv6 = append(ipv6, n)
+		}
+	}
+	return append(ipv4, ipv6...)
+}
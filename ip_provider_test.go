@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestIPWorkerId(t *testing.T) {
+	tests := []struct {
+		ip   string
+		bits uint
+		want int64
+	}{
+		{"10.0.1.2", 8, 2},
+		{"10.0.1.2", 10, 258},
+		{"10.0.1.2", 16, 258},
+		{"192.168.255.255", 8, 255},
+		{"::ffff:10.0.1.2", 8, 2},
+		{"fd00::1:203", 10, 515},
+	}
+	for _, tt := range tests {
+		if got := ipWorkerId(net.ParseIP(tt.ip), tt.bits); got != tt.want {
+			t.Errorf("ipWorkerId(%s, %d) = %d, want %d", tt.ip, tt.bits, got, tt.want)
+		}
+	}
+}
+
+func TestSelectAddrs(t *testing.T) {
+	var addrs []net.Addr
+	for _, a := range []string{"127.0.0.1/8", "fe80::1/64", "fd00::2/64", "10.0.1.2/24", "192.168.0.3/16", "169.254.0.4/16"} {
+		ip, n, _ := net.ParseCIDR(a)
+		n.IP = ip
+		addrs = append(addrs, n)
+	}
+	addrs = append(addrs, &net.IPAddr{IP: net.ParseIP("10.0.1.5")})
+	tests := []struct {
+		cidr string
+		want string
+	}{
+		{"", "10.0.1.2,192.168.0.3,fd00::2"},
+		{"10.0.0.0/8", "10.0.1.2"},
+		{"fd00::/8", "fd00::2"},
+		{"172.16.0.0/12", ""},
+	}
+	for _, tt := range tests {
+		var cidr *net.IPNet
+		if tt.cidr != "" {
+			_, cidr, _ = net.ParseCIDR(tt.cidr)
+		}
+		var got []string
+		for _, n := range selectAddrs(addrs, cidr) {
+			got = append(got, n.IP.String())
+		}
+		if strings.Join(got, ",") != tt.want {
+			t.Errorf("selectAddrs(%q) = %s, want %s", tt.cidr, strings.Join(got, ","), tt.want)
+		}
+	}
+}
@@ -5,16 +5,22 @@ import (
 	"flag"
 	"fmt"
 	"git.shiyou.kingsoft.com/go/graceful"
+	"git.shiyou.kingsoft.com/infra/snowflake-service/idlayout"
 	snowflakepb "git.shiyou.kingsoft.com/infra/snowflake-service/proto"
+	"github.com/go-redis/redis/v8"
 	grpc_recovery "github.com/grpc-ecosystem/go-grpc-middleware/recovery"
 	grpc_prometheus "github.com/grpc-ecosystem/go-grpc-prometheus"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/status"
 	"log"
+	"math"
 	"net"
 	"net/http"
+	"strings"
 	"time"
 )
 
@@ -24,10 +30,44 @@ var (
 	metricsPort            uint64
 	provider               string
 	enableSelfPreservation bool
-	consulAddress          string
+	safeSelfPreservation   bool
+	consulClient           ConsulClientConfig
 	consulKeyPrefix        string
+	consulSession          = ConsulSessionConfig{Behavior: "release"}
+	consulNodeChecks       string
+	consulServiceChecks    string
+	consulRegisterService  bool
+	consulService          ConsulServiceConfig
 	hintWorkerId           uint64
 	workerId               uint64
+	maxBatchSize           uint64
+	streamChunkSize        uint64
+	maxRollbackWait        time.Duration
+	maxRollbackBorrow      time.Duration
+	sequencePolicy         = SequencePolicy{Strategy: SequenceWaitSleep, Start: SequenceStartZero}
+	layout                 = idlayout.DefaultLayout
+	epochValue             string
+	datacenterId           uint64
+	datacenterMapping      string
+	etcdEndpoints          string
+	etcdKeyPrefix          string
+	etcdLeaseTTL           int
+	redisAddress           string
+	redisPassword          string
+	redisDB                int
+	redisKeyPrefix         string
+	redisLeaseTTL          time.Duration
+	statefulSetBase        int64
+	stateFile              string
+	stateSaveInterval      time.Duration
+	sqlDriver              string
+	sqlDSN                 string
+	sqlTable               string
+	sqlLeaseTTL            time.Duration
+	fileLockDir            string
+	ipSelector             string
+	staticMapFile          string
+	staticMapInstance      string
 )
 
 func main() {
@@ -35,22 +75,175 @@ func main() {
 	flag.StringVar(&host, "host", "0.0.0.0", "Which host the server listening on")
 	flag.Uint64Var(&grpcPort, "rpc-port", 8080, "gRPC listen port")
 	flag.Uint64Var(&metricsPort, "metrics-port", 8090, "/metrics http endpoint listen port")
-	flag.StringVar(&provider, "provider", "consul", "What provider to get the snowflake worker id:[simple, consul], default is consul")
+	flag.StringVar(&provider, "provider", "consul", "What provider to get the snowflake worker id:[simple, consul, etcd, redis, statefulset, sql, filelock, ip, staticmap], default is consul")
 	flag.BoolVar(&enableSelfPreservation, "enable-self-preservation", true, "If the provider lost the worker id then use the latest available or the hint worker id")
-	flag.StringVar(&consulAddress, "consul-address", "localhost:8500", "Address to the consul")
+	flag.BoolVar(&safeSelfPreservation, "safe-self-preservation", false, "Only use the lost worker id for a grace period shorter than others could acquire it, then refuse to generate ids, the hint worker id is never used")
+	flag.StringVar(&consulClient.Address, "consul-address", "localhost:8500", "Address to the consul")
+	flag.StringVar(&consulClient.Scheme, "consul-scheme", "", "Scheme to the consul:[http, https], defaults to CONSUL_HTTP_SSL or http")
+	flag.StringVar(&consulClient.Token, "consul-token", "", "ACL token of the consul, defaults to CONSUL_HTTP_TOKEN")
+	flag.StringVar(&consulClient.TokenFile, "consul-token-file", "", "File containing the ACL token of the consul, defaults to CONSUL_HTTP_TOKEN_FILE")
+	flag.StringVar(&consulClient.CAFile, "consul-ca-file", "", "CA file to verify the consul server certificate, defaults to CONSUL_CACERT")
+	flag.StringVar(&consulClient.CertFile, "consul-cert-file", "", "Client certificate file for the consul, defaults to CONSUL_CLIENT_CERT")
+	flag.StringVar(&consulClient.KeyFile, "consul-key-file", "", "Client key file for the consul, defaults to CONSUL_CLIENT_KEY")
+	flag.StringVar(&consulClient.TLSServerName, "consul-tls-server-name", "", "Server name to verify the consul server certificate, defaults to CONSUL_TLS_SERVER_NAME")
+	flag.StringVar(&consulClient.Datacenter, "consul-datacenter", "", "Datacenter of the consul, defaults to the datacenter of the agent")
+	flag.StringVar(&consulClient.Namespace, "consul-namespace", "", "Namespace of the consul enterprise, defaults to CONSUL_NAMESPACE")
+	flag.StringVar(&consulClient.Partition, "consul-partition", "", "Admin partition of the consul enterprise, defaults to CONSUL_PARTITION")
 	flag.StringVar(&consulKeyPrefix, "consul-key-prefix", "snowflake/worker/id/", "Consul kv prefix")
+	flag.DurationVar(&consulSession.TTL, "consul-session-ttl", 15*time.Second, "TTL of the consul session the worker id lock is bound to, between 10s and 24h")
+	flag.DurationVar(&consulSession.LockDelay, "consul-lock-delay", 15*time.Second, "Others can not acquire the worker id within the lock delay after the consul session is invalidated, between 1ms and 1m")
+	flag.StringVar(&consulSession.Behavior, "consul-session-behavior", consulSession.Behavior, "Release or delete the lock key when the consul session is invalidated:[release, delete]")
+	flag.StringVar(&consulNodeChecks, "consul-session-node-checks", "serfHealth", "Comma separated node check ids the consul session is bound to, the session is invalidated once any check is critical")
+	flag.BoolVar(&consulRegisterService, "consul-register-service", true, "Register the service in the consul catalog with gRPC health checks when the provider is consul, the consul session is bound to its liveness check")
+	flag.StringVar(&consulService.Name, "consul-service-name", "snowflake-service", "Name of the service registered in the consul catalog")
+	flag.StringVar(&consulService.Address, "consul-service-address", "", "Advertised address of the service registered in the consul, the address of the agent node is used if empty")
+	flag.DurationVar(&consulService.CheckInterval, "consul-check-interval", 10*time.Second, "Interval of the gRPC health check of the service registered in the consul")
+	flag.DurationVar(&consulService.DeregisterCriticalAfter, "consul-deregister-critical-after", time.Minute, "Consul deregisters the service if the liveness check is critical for this duration")
+	flag.StringVar(&consulServiceChecks, "consul-session-service-checks", "", "Comma separated service check ids the consul session is bound to, besides the liveness check of the registered service")
+	flag.StringVar(&etcdEndpoints, "etcd-endpoints", "localhost:2379", "Comma separated etcd endpoints")
+	flag.StringVar(&etcdKeyPrefix, "etcd-key-prefix", "snowflake/worker/id/", "Etcd key prefix")
+	flag.IntVar(&etcdLeaseTTL, "etcd-lease-ttl", 10, "TTL in seconds of the etcd lease the worker id lock is bound to")
+	flag.StringVar(&redisAddress, "redis-address", "localhost:6379", "Address to the redis")
+	flag.StringVar(&redisPassword, "redis-password", "", "Password of the redis")
+	flag.IntVar(&redisDB, "redis-db", 0, "Database of the redis")
+	flag.StringVar(&redisKeyPrefix, "redis-key-prefix", "snowflake/worker/id/", "Redis key prefix")
+	flag.DurationVar(&redisLeaseTTL, "redis-lease-ttl", 10*time.Second, "TTL of the redis key the worker id is claimed by, the key is renewed every third of the TTL")
+	flag.StringVar(&sqlDriver, "sql-driver", "mysql", "Database driver of the sql provider:[mysql, postgres, sqlite]")
+	flag.StringVar(&sqlDSN, "sql-dsn", "", "Data source name of the sql provider, e.g. user:password@tcp(localhost:3306)/snowflake")
+	flag.StringVar(&sqlTable, "sql-table", "snowflake_worker_id", "Table of the worker id leases, created if not exists")
+	flag.DurationVar(&sqlLeaseTTL, "sql-lease-ttl", 10*time.Second, "TTL of the worker id lease in the database, the lease is renewed every third of the TTL")
+	flag.StringVar(&fileLockDir, "filelock-dir", "/var/run/snowflake", "Directory of the <worker id>.lock files the filelock provider locks")
+	flag.StringVar(&ipSelector, "ip-selector", "", "Interface name or CIDR to select the ip address the ip provider derives the worker id from, the first global unicast address is used if empty")
+	flag.StringVar(&staticMapFile, "static-map-file", "", "YAML or JSON file mapping the hostnames, ip addresses or instance tags to the worker ids, reloaded on SIGHUP")
+	flag.StringVar(&staticMapInstance, "static-map-instance", "", "Instance tag the staticmap provider looks up, the hostname and the ip addresses are looked up if empty")
+	flag.StringVar(&stateFile, "state-file", "", "File to persist the acquired worker id and the last timestamp across restarts, disabled if empty")
+	flag.DurationVar(&stateSaveInterval, "state-save-interval", time.Second, "Interval to save the state file, a restart after crash waits at most this duration for the clock")
+	flag.Int64Var(&statefulSetBase, "statefulset-worker-id-base", 0, "The statefulset provider uses the pod ordinal plus this base as the worker id")
 	flag.Uint64Var(&hintWorkerId, "hint-worker-id", 0, "Acquire worker id start with the hint worker id")
 	flag.Uint64Var(&workerId, "worker-id", 0, "Specify a worker id to the simple provider")
+	flag.Uint64Var(&datacenterId, "datacenter-id", 0, "Specify a datacenter id to the simple provider")
+	flag.StringVar(&datacenterMapping, "consul-datacenter-mapping", "", "Map the datacenter of the consul agent to a datacenter id, e.g. dc1=0,dc2=1, the datacenter id is 0 if empty")
+	flag.Uint64Var(&maxBatchSize, "max-batch-size", 100000, "The max number of ids a single NextIds call can acquire")
+	flag.Uint64Var(&streamChunkSize, "stream-chunk-size", 1000, "The default number of ids per StreamIds response, capped by max-batch-size")
+	flag.DurationVar(&maxRollbackWait, "max-rollback-wait", 10*time.Millisecond, "Wait for the clock to catch up if it moved backwards no more than this duration")
+	flag.DurationVar(&maxRollbackBorrow, "max-rollback-borrow", time.Second, "Borrow a rollback counter if the clock moved backwards no more than this duration, otherwise refuse to generate ids")
+	flag.StringVar(&sequencePolicy.Strategy, "sequence-wait-strategy", sequencePolicy.Strategy, "What to do when the sequence of a millisecond is exhausted:[sleep, borrow], sleep until the next millisecond or borrow the next millisecond")
+	flag.StringVar(&sequencePolicy.Start, "sequence-start", sequencePolicy.Start, "Where the sequence of each millisecond starts:[zero, random], random starts at a hash of the timestamp and a process salt for a uniform id % N")
+	flag.DurationVar(&sequencePolicy.MaxLead, "max-sequence-borrow", 10*time.Millisecond, "The borrowed timestamp leads the clock no more than this duration, otherwise sleep until the clock catches up")
+	flag.UintVar(&layout.TimestampBits, "timestamp-bits", idlayout.DefaultLayout.TimestampBits, "Number of bits of the timestamp in an id")
+	flag.UintVar(&layout.RollbackBits, "rollback-bits", idlayout.DefaultLayout.RollbackBits, "Number of bits of the clock rollback counter in an id, 0 disables borrowing on clock rollback")
+	flag.UintVar(&layout.DatacenterIdBits, "datacenter-id-bits", idlayout.DefaultLayout.DatacenterIdBits, "Number of bits of the datacenter id in an id")
+	flag.UintVar(&layout.WorkerIdBits, "worker-id-bits", idlayout.DefaultLayout.WorkerIdBits, "Number of bits of the worker id in an id")
+	flag.UintVar(&layout.SequenceBits, "sequence-bits", idlayout.DefaultLayout.SequenceBits, "Number of bits of the sequence in an id")
+	flag.StringVar(&epochValue, "epoch", time.UnixMilli(defaultEpoch).In(time.FixedZone("CST", 8*3600)).Format(time.RFC3339), "The epoch of the id timestamp, in RFC3339 or unix milliseconds")
 	flag.Parse()
 
 	// =========================== init snowflake =================================
+	if err := layout.Validate(); err != nil {
+		log.Fatalf("Invalid layout: %v", err)
+	}
+	epoch, err := parseEpoch(epochValue)
+	if err != nil {
+		log.Fatalf("Invalid epoch: %v", err)
+	}
+	if err := validateEpoch(epoch, layout, time.Now().UnixMilli()); err != nil {
+		log.Fatalf("Invalid epoch: %v", err)
+	}
+	log.Printf("Snowflake layout %s: %d datacenter ids, %d worker ids, %d ids per millisecond per worker, available until %s",
+		layout, layout.MaxDatacenterId()+1, layout.MaxWorkerId()+1, layout.SequenceMask()+1,
+		time.UnixMilli(epoch+layout.MaxTimestamp()).UTC().Format(time.RFC3339))
+	if layout.RollbackBits == 0 {
+		log.Printf("rollback-bits is 0, the clock moving backwards more than max-rollback-wait is refused instead of borrowing a rollback counter")
+	}
+	var persisted State
+	var restored bool
+	if stateFile != "" {
+		if stateSaveInterval <= 0 {
+			log.Fatalf("state-save-interval must be positive")
+		}
+		if persisted, restored, err = loadState(stateFile); err != nil {
+			log.Fatalf("Load state file %s error: %v", stateFile, err)
+		}
+		if !restored || persisted.WorkerId < 0 || persisted.WorkerId > layout.MaxWorkerId() {
+			persisted.WorkerId = -1
+		} else {
+			log.Printf("state file %s restores worker id %d and timestamp %d, prefer reclaiming the worker id", stateFile, persisted.WorkerId, persisted.Timestamp)
+			hintWorkerId = uint64(persisted.WorkerId)
+		}
+	}
 	var p Provider
-	if provider == "simple" {
-		p = getSimpleProvider(int64(workerId))
-	} else {
-		p = getConsulProvider(consulAddress, consulKeyPrefix, int64(hintWorkerId), enableSelfPreservation)
+	switch provider {
+	case "simple":
+		p = getSimpleProvider(int64(workerId), layout.MaxWorkerId(), int64(datacenterId), layout.MaxDatacenterId())
+	case "etcd":
+		p = getEtcdProvider(strings.Split(etcdEndpoints, ","), etcdKeyPrefix, etcdLeaseTTL, int64(hintWorkerId), layout.MaxWorkerId(), enableSelfPreservation, safeSelfPreservation)
+	case "redis":
+		if redisLeaseTTL < 3*time.Millisecond {
+			log.Fatalf("redis-lease-ttl must be at least 3ms")
+		}
+		options := &redis.Options{Addr: redisAddress, Password: redisPassword, DB: redisDB}
+		p = getRedisProvider(options, redisKeyPrefix, redisLeaseTTL, int64(hintWorkerId), layout.MaxWorkerId(), enableSelfPreservation, safeSelfPreservation)
+	case "sql":
+		if sqlLeaseTTL < 3*time.Millisecond {
+			log.Fatalf("sql-lease-ttl must be at least 3ms")
+		}
+		p = getSQLProvider(sqlDriver, sqlDSN, sqlTable, sqlLeaseTTL, int64(hintWorkerId), layout.MaxWorkerId(), enableSelfPreservation, safeSelfPreservation)
+	case "filelock":
+		p = getFileLockProvider(fileLockDir, int64(hintWorkerId), layout.MaxWorkerId())
+	case "ip":
+		p = getIPProvider(ipSelector, layout.WorkerIdBits)
+	case "staticmap":
+		p = getStaticMapProvider(staticMapFile, staticMapInstance, layout.MaxWorkerId())
+	case "statefulset":
+		p = getStatefulSetProvider(statefulSetBase, layout.MaxWorkerId())
+	default:
+		mapping, err := parseDatacenterMapping(datacenterMapping, layout.MaxDatacenterId())
+		if err != nil {
+			log.Fatalf("Invalid consul-datacenter-mapping: %v", err)
+		}
+		consulSession.NodeChecks = splitList(consulNodeChecks)
+		consulSession.ServiceChecks = splitList(consulServiceChecks)
+		var service *ConsulServiceConfig
+		if consulRegisterService {
+			checkHost, err := serviceCheckHost(consulService.Address, host, consulClient.apiConfig().Address)
+			if err != nil {
+				log.Fatalf("Register the service in consul error: %v", err)
+			}
+			consulService.CheckHost = checkHost
+			consulService.Port = int(grpcPort)
+			service = &consulService
+		}
+		cp := getConsulProvider(consulClient, consulKeyPrefix, int64(hintWorkerId), layout.MaxWorkerId(), mapping, consulSession, service, enableSelfPreservation, safeSelfPreservation)
+		p = cp
+	}
+	p.Subscribe(observeProviderEvent)
+	if maxRollbackWait < 0 || maxRollbackBorrow < maxRollbackWait {
+		log.Fatalf("max-rollback-wait must not be negative and max-rollback-borrow must not be less than max-rollback-wait")
+	}
+	if sequencePolicy.Strategy != SequenceWaitSleep && sequencePolicy.Strategy != SequenceWaitBorrow {
+		log.Fatalf("sequence-wait-strategy must be %s or %s", SequenceWaitSleep, SequenceWaitBorrow)
+	}
+	if sequencePolicy.Strategy == SequenceWaitBorrow && (sequencePolicy.MaxLead < time.Millisecond || sequencePolicy.MaxLead > time.Second) {
+		log.Fatalf("max-sequence-borrow must between 1ms and 1s")
+	}
+	if sequencePolicy.Start != SequenceStartZero && sequencePolicy.Start != SequenceStartRandom {
+		log.Fatalf("sequence-start must be %s or %s", SequenceStartZero, SequenceStartRandom)
+	}
+	sf := initSnowflake(p, layout, epoch, RollbackPolicy{MaxWait: maxRollbackWait, MaxBorrow: maxRollbackBorrow}, sequencePolicy)
+	prometheus.MustRegister(newRemainingLifetimeGauge(sf), newLeadGauge(sf))
+	var keeper *stateKeeper
+	if stateFile != "" {
+		if restored {
+			sf.RestoreTimestamp(persisted.Timestamp)
+		}
+		keeper = newStateKeeper(stateFile, stateSaveInterval, sf, p, persisted.WorkerId)
+	}
+	if maxBatchSize == 0 || maxBatchSize > math.MaxUint32 {
+		log.Fatalf("max-batch-size must between 1 and %d", uint32(math.MaxUint32))
+	}
+	if streamChunkSize == 0 || streamChunkSize > maxBatchSize {
+		log.Fatalf("stream-chunk-size must between 1 and max-batch-size %d", maxBatchSize)
 	}
-	initSnowflake(p)
 
 	// =========================== init gRPC server =================================
 	// Create a listener on TCP port
@@ -69,8 +262,15 @@ func main() {
 			grpc_prometheus.UnaryServerInterceptor,
 			grpc_recovery.UnaryServerInterceptor(recovery_opts...),
 		),
+		grpc.ChainStreamInterceptor(
+			grpc_prometheus.StreamServerInterceptor,
+			grpc_recovery.StreamServerInterceptor(recovery_opts...),
+		),
 	)
-	snowflakepb.RegisterSnowflakeServer(s, &Server{})
+	server := newServer(uint32(maxBatchSize), uint32(streamChunkSize))
+	snowflakepb.RegisterSnowflakeServer(s, server)
+	healthServer := newHealthServer(p)
+	healthpb.RegisterHealthServer(s, healthServer)
 	grpc_prometheus.Register(s)
 	// Serve gRPC server
 	log.Printf("Serving gRPC on %s:%d", host, grpcPort)
@@ -96,11 +296,30 @@ func main() {
 		15*time.Second,
 		[]graceful.Operation{
 			func(ctx context.Context) {
+				healthServer.Shutdown()
+				if cp, ok := p.(*ConsulProvider); ok {
+					cp.DeregisterService()
+				}
 				p.Stop()
+				server.Stop()
 				s.GracefulStop()
+				if keeper != nil {
+					keeper.Stop()
+				}
 			},
 		},
 	)
 	<-shutdown
 	log.Println("Good bye ...")
 }
+
+// splitList splits a comma separated flag value, the empty items are dropped
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
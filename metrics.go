@@ -0,0 +1,76 @@
+package main
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var clockRollbackCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "snowflake_clock_rollback_total",
+		Help: "Total number of clock rollbacks detected by the snowflake, partitioned by the action taken: wait, borrow or reject.",
+	},
+	[]string{"action"},
+)
+
+var sequenceExhaustedCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "snowflake_sequence_exhausted_total",
+		Help: "Total number of times the sequence of a millisecond is exhausted, partitioned by the action taken: sleep or borrow.",
+	},
+	[]string{"action"},
+)
+
+var providerEventCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "snowflake_provider_events_total",
+		Help: "Total number of events published by the worker id provider, partitioned by the event: acquiring, acquired, lost, self_preserving or stopped.",
+	},
+	[]string{"event"},
+)
+
+var workerIdGauge = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "snowflake_worker_id",
+		Help: "The worker id currently used by the snowflake, -1 if no worker id is acquired.",
+	},
+)
+
+func init() {
+	workerIdGauge.Set(-1)
+	prometheus.MustRegister(clockRollbackCounter, sequenceExhaustedCounter, providerEventCounter, workerIdGauge)
+}
+
+// observeProviderEvent is subscribed to the provider to count the events and track the worker id in use.
+func observeProviderEvent(e ProviderEvent) {
+	providerEventCounter.WithLabelValues(e.Type.String()).Inc()
+	switch e.Type {
+	case ProviderAcquired, ProviderSelfPreserving:
+		workerIdGauge.Set(float64(e.WorkerId))
+	case ProviderLost, ProviderStopped:
+		workerIdGauge.Set(-1)
+	}
+}
+
+// newLeadGauge reports how far the timestamp borrowed by the sequence-wait-strategy=borrow leads the clock.
+func newLeadGauge(s *Snowflake) prometheus.GaugeFunc {
+	return prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "snowflake_timestamp_lead_seconds",
+			Help: "Seconds the timestamp of the latest id leads the clock, 0 if the timestamp is not borrowed.",
+		},
+		func() float64 {
+			return s.Lead().Seconds()
+		},
+	)
+}
+
+// newRemainingLifetimeGauge reports how long the Snowflake can generate ids before its timestamp is exhausted.
+func newRemainingLifetimeGauge(s *Snowflake) prometheus.GaugeFunc {
+	return prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "snowflake_remaining_lifetime_seconds",
+			Help: "Remaining seconds before the timestamp of the snowflake ids is exhausted.",
+		},
+		func() float64 {
+			return s.RemainingLifetime().Seconds()
+		},
+	)
+}
@@ -9,6 +9,7 @@ package snowflake
 import (
 	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
 	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
 	reflect "reflect"
 	sync "sync"
 )
@@ -105,25 +106,405 @@ func (x *NextIdResponse) GetId() uint64 {
 	return 0
 }
 
+type NextIdsRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Count uint32 `protobuf:"varint,1,opt,name=count,proto3" json:"count,omitempty"`
+}
+
+func (x *NextIdsRequest) Reset() {
+	*x = NextIdsRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_snowflake_proto_msgTypes[2]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *NextIdsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*NextIdsRequest) ProtoMessage() {}
+
+func (x *NextIdsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_snowflake_proto_msgTypes[2]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use NextIdsRequest.ProtoReflect.Descriptor instead.
+func (*NextIdsRequest) Descriptor() ([]byte, []int) {
+	return file_snowflake_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *NextIdsRequest) GetCount() uint32 {
+	if x != nil {
+		return x.Count
+	}
+	return 0
+}
+
+type NextIdsResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Ids []uint64 `protobuf:"fixed64,1,rep,packed,name=ids,proto3" json:"ids,omitempty"`
+}
+
+func (x *NextIdsResponse) Reset() {
+	*x = NextIdsResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_snowflake_proto_msgTypes[3]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *NextIdsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*NextIdsResponse) ProtoMessage() {}
+
+func (x *NextIdsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_snowflake_proto_msgTypes[3]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use NextIdsResponse.ProtoReflect.Descriptor instead.
+func (*NextIdsResponse) Descriptor() ([]byte, []int) {
+	return file_snowflake_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *NextIdsResponse) GetIds() []uint64 {
+	if x != nil {
+		return x.Ids
+	}
+	return nil
+}
+
+type StreamIdsRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// The number of additional ids the client is willing to receive.
+	Credit uint64 `protobuf:"varint,1,opt,name=credit,proto3" json:"credit,omitempty"`
+	// The max number of ids per response, 0 means the server default.
+	ChunkSize uint32 `protobuf:"varint,2,opt,name=chunk_size,json=chunkSize,proto3" json:"chunk_size,omitempty"`
+}
+
+func (x *StreamIdsRequest) Reset() {
+	*x = StreamIdsRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_snowflake_proto_msgTypes[4]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *StreamIdsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StreamIdsRequest) ProtoMessage() {}
+
+func (x *StreamIdsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_snowflake_proto_msgTypes[4]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StreamIdsRequest.ProtoReflect.Descriptor instead.
+func (*StreamIdsRequest) Descriptor() ([]byte, []int) {
+	return file_snowflake_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *StreamIdsRequest) GetCredit() uint64 {
+	if x != nil {
+		return x.Credit
+	}
+	return 0
+}
+
+func (x *StreamIdsRequest) GetChunkSize() uint32 {
+	if x != nil {
+		return x.ChunkSize
+	}
+	return 0
+}
+
+type StreamIdsResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Ids []uint64 `protobuf:"fixed64,1,rep,packed,name=ids,proto3" json:"ids,omitempty"`
+}
+
+func (x *StreamIdsResponse) Reset() {
+	*x = StreamIdsResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_snowflake_proto_msgTypes[5]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *StreamIdsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StreamIdsResponse) ProtoMessage() {}
+
+func (x *StreamIdsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_snowflake_proto_msgTypes[5]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StreamIdsResponse.ProtoReflect.Descriptor instead.
+func (*StreamIdsResponse) Descriptor() ([]byte, []int) {
+	return file_snowflake_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *StreamIdsResponse) GetIds() []uint64 {
+	if x != nil {
+		return x.Ids
+	}
+	return nil
+}
+
+type ParseIdRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Id uint64 `protobuf:"fixed64,1,opt,name=id,proto3" json:"id,omitempty"`
+}
+
+func (x *ParseIdRequest) Reset() {
+	*x = ParseIdRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_snowflake_proto_msgTypes[6]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ParseIdRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ParseIdRequest) ProtoMessage() {}
+
+func (x *ParseIdRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_snowflake_proto_msgTypes[6]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ParseIdRequest.ProtoReflect.Descriptor instead.
+func (*ParseIdRequest) Descriptor() ([]byte, []int) {
+	return file_snowflake_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *ParseIdRequest) GetId() uint64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+type ParseIdResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// Milliseconds since the epoch of the server.
+	Timestamp int64 `protobuf:"varint,1,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	// The wall clock time the id was generated at.
+	Time         *timestamppb.Timestamp `protobuf:"bytes,2,opt,name=time,proto3" json:"time,omitempty"`
+	Rollback     uint64                 `protobuf:"varint,3,opt,name=rollback,proto3" json:"rollback,omitempty"`
+	DatacenterId uint64                 `protobuf:"varint,4,opt,name=datacenter_id,json=datacenterId,proto3" json:"datacenter_id,omitempty"`
+	WorkerId     uint64                 `protobuf:"varint,5,opt,name=worker_id,json=workerId,proto3" json:"worker_id,omitempty"`
+	Sequence     uint64                 `protobuf:"varint,6,opt,name=sequence,proto3" json:"sequence,omitempty"`
+}
+
+func (x *ParseIdResponse) Reset() {
+	*x = ParseIdResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_snowflake_proto_msgTypes[7]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ParseIdResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ParseIdResponse) ProtoMessage() {}
+
+func (x *ParseIdResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_snowflake_proto_msgTypes[7]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ParseIdResponse.ProtoReflect.Descriptor instead.
+func (*ParseIdResponse) Descriptor() ([]byte, []int) {
+	return file_snowflake_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *ParseIdResponse) GetTimestamp() int64 {
+	if x != nil {
+		return x.Timestamp
+	}
+	return 0
+}
+
+func (x *ParseIdResponse) GetTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Time
+	}
+	return nil
+}
+
+func (x *ParseIdResponse) GetRollback() uint64 {
+	if x != nil {
+		return x.Rollback
+	}
+	return 0
+}
+
+func (x *ParseIdResponse) GetDatacenterId() uint64 {
+	if x != nil {
+		return x.DatacenterId
+	}
+	return 0
+}
+
+func (x *ParseIdResponse) GetWorkerId() uint64 {
+	if x != nil {
+		return x.WorkerId
+	}
+	return 0
+}
+
+func (x *ParseIdResponse) GetSequence() uint64 {
+	if x != nil {
+		return x.Sequence
+	}
+	return 0
+}
+
 var File_snowflake_proto protoreflect.FileDescriptor
 
 var file_snowflake_proto_rawDesc = []byte{
 	0x0a, 0x0f, 0x73, 0x6e, 0x6f, 0x77, 0x66, 0x6c, 0x61, 0x6b, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74,
 	0x6f, 0x12, 0x10, 0x73, 0x65, 0x61, 0x79, 0x6f, 0x6f, 0x2e, 0x73, 0x6e, 0x6f, 0x77, 0x66, 0x6c,
-	0x61, 0x6b, 0x65, 0x22, 0x0f, 0x0a, 0x0d, 0x4e, 0x65, 0x78, 0x74, 0x49, 0x64, 0x52, 0x65, 0x71,
-	0x75, 0x65, 0x73, 0x74, 0x22, 0x20, 0x0a, 0x0e, 0x4e, 0x65, 0x78, 0x74, 0x49, 0x64, 0x52, 0x65,
-	0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x0e, 0x0a, 0x02, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01,
-	0x28, 0x06, 0x52, 0x02, 0x69, 0x64, 0x32, 0x5a, 0x0a, 0x09, 0x53, 0x6e, 0x6f, 0x77, 0x66, 0x6c,
-	0x61, 0x6b, 0x65, 0x12, 0x4d, 0x0a, 0x06, 0x4e, 0x65, 0x78, 0x74, 0x49, 0x64, 0x12, 0x1f, 0x2e,
+	0x61, 0x6b, 0x65, 0x1a, 0x1f, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2f, 0x70, 0x72, 0x6f, 0x74,
+	0x6f, 0x62, 0x75, 0x66, 0x2f, 0x74, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x2e, 0x70,
+	0x72, 0x6f, 0x74, 0x6f, 0x22, 0x0f, 0x0a, 0x0d, 0x4e, 0x65, 0x78, 0x74, 0x49, 0x64, 0x52, 0x65,
+	0x71, 0x75, 0x65, 0x73, 0x74, 0x22, 0x20, 0x0a, 0x0e, 0x4e, 0x65, 0x78, 0x74, 0x49, 0x64, 0x52,
+	0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x0e, 0x0a, 0x02, 0x69, 0x64, 0x18, 0x01, 0x20,
+	0x01, 0x28, 0x06, 0x52, 0x02, 0x69, 0x64, 0x22, 0x26, 0x0a, 0x0e, 0x4e, 0x65, 0x78, 0x74, 0x49,
+	0x64, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x14, 0x0a, 0x05, 0x63, 0x6f, 0x75,
+	0x6e, 0x74, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x05, 0x63, 0x6f, 0x75, 0x6e, 0x74, 0x22,
+	0x23, 0x0a, 0x0f, 0x4e, 0x65, 0x78, 0x74, 0x49, 0x64, 0x73, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e,
+	0x73, 0x65, 0x12, 0x10, 0x0a, 0x03, 0x69, 0x64, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x06, 0x52,
+	0x03, 0x69, 0x64, 0x73, 0x22, 0x49, 0x0a, 0x10, 0x53, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x49, 0x64,
+	0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x16, 0x0a, 0x06, 0x63, 0x72, 0x65, 0x64,
+	0x69, 0x74, 0x18, 0x01, 0x20, 0x01, 0x28, 0x04, 0x52, 0x06, 0x63, 0x72, 0x65, 0x64, 0x69, 0x74,
+	0x12, 0x1d, 0x0a, 0x0a, 0x63, 0x68, 0x75, 0x6e, 0x6b, 0x5f, 0x73, 0x69, 0x7a, 0x65, 0x18, 0x02,
+	0x20, 0x01, 0x28, 0x0d, 0x52, 0x09, 0x63, 0x68, 0x75, 0x6e, 0x6b, 0x53, 0x69, 0x7a, 0x65, 0x22,
+	0x25, 0x0a, 0x11, 0x53, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x49, 0x64, 0x73, 0x52, 0x65, 0x73, 0x70,
+	0x6f, 0x6e, 0x73, 0x65, 0x12, 0x10, 0x0a, 0x03, 0x69, 0x64, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28,
+	0x06, 0x52, 0x03, 0x69, 0x64, 0x73, 0x22, 0x20, 0x0a, 0x0e, 0x50, 0x61, 0x72, 0x73, 0x65, 0x49,
+	0x64, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x0e, 0x0a, 0x02, 0x69, 0x64, 0x18, 0x01,
+	0x20, 0x01, 0x28, 0x06, 0x52, 0x02, 0x69, 0x64, 0x22, 0xd9, 0x01, 0x0a, 0x0f, 0x50, 0x61, 0x72,
+	0x73, 0x65, 0x49, 0x64, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x1c, 0x0a, 0x09,
+	0x74, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x18, 0x01, 0x20, 0x01, 0x28, 0x03, 0x52,
+	0x09, 0x74, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x12, 0x2e, 0x0a, 0x04, 0x74, 0x69,
+	0x6d, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c,
+	0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x54, 0x69, 0x6d, 0x65, 0x73,
+	0x74, 0x61, 0x6d, 0x70, 0x52, 0x04, 0x74, 0x69, 0x6d, 0x65, 0x12, 0x1a, 0x0a, 0x08, 0x72, 0x6f,
+	0x6c, 0x6c, 0x62, 0x61, 0x63, 0x6b, 0x18, 0x03, 0x20, 0x01, 0x28, 0x04, 0x52, 0x08, 0x72, 0x6f,
+	0x6c, 0x6c, 0x62, 0x61, 0x63, 0x6b, 0x12, 0x23, 0x0a, 0x0d, 0x64, 0x61, 0x74, 0x61, 0x63, 0x65,
+	0x6e, 0x74, 0x65, 0x72, 0x5f, 0x69, 0x64, 0x18, 0x04, 0x20, 0x01, 0x28, 0x04, 0x52, 0x0c, 0x64,
+	0x61, 0x74, 0x61, 0x63, 0x65, 0x6e, 0x74, 0x65, 0x72, 0x49, 0x64, 0x12, 0x1b, 0x0a, 0x09, 0x77,
+	0x6f, 0x72, 0x6b, 0x65, 0x72, 0x5f, 0x69, 0x64, 0x18, 0x05, 0x20, 0x01, 0x28, 0x04, 0x52, 0x08,
+	0x77, 0x6f, 0x72, 0x6b, 0x65, 0x72, 0x49, 0x64, 0x12, 0x1a, 0x0a, 0x08, 0x73, 0x65, 0x71, 0x75,
+	0x65, 0x6e, 0x63, 0x65, 0x18, 0x06, 0x20, 0x01, 0x28, 0x04, 0x52, 0x08, 0x73, 0x65, 0x71, 0x75,
+	0x65, 0x6e, 0x63, 0x65, 0x32, 0xda, 0x02, 0x0a, 0x09, 0x53, 0x6e, 0x6f, 0x77, 0x66, 0x6c, 0x61,
+	0x6b, 0x65, 0x12, 0x4d, 0x0a, 0x06, 0x4e, 0x65, 0x78, 0x74, 0x49, 0x64, 0x12, 0x1f, 0x2e, 0x73,
+	0x65, 0x61, 0x79, 0x6f, 0x6f, 0x2e, 0x73, 0x6e, 0x6f, 0x77, 0x66, 0x6c, 0x61, 0x6b, 0x65, 0x2e,
+	0x4e, 0x65, 0x78, 0x74, 0x49, 0x64, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x20, 0x2e,
 	0x73, 0x65, 0x61, 0x79, 0x6f, 0x6f, 0x2e, 0x73, 0x6e, 0x6f, 0x77, 0x66, 0x6c, 0x61, 0x6b, 0x65,
-	0x2e, 0x4e, 0x65, 0x78, 0x74, 0x49, 0x64, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x20,
+	0x2e, 0x4e, 0x65, 0x78, 0x74, 0x49, 0x64, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x22,
+	0x00, 0x12, 0x50, 0x0a, 0x07, 0x4e, 0x65, 0x78, 0x74, 0x49, 0x64, 0x73, 0x12, 0x20, 0x2e, 0x73,
+	0x65, 0x61, 0x79, 0x6f, 0x6f, 0x2e, 0x73, 0x6e, 0x6f, 0x77, 0x66, 0x6c, 0x61, 0x6b, 0x65, 0x2e,
+	0x4e, 0x65, 0x78, 0x74, 0x49, 0x64, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x21,
 	0x2e, 0x73, 0x65, 0x61, 0x79, 0x6f, 0x6f, 0x2e, 0x73, 0x6e, 0x6f, 0x77, 0x66, 0x6c, 0x61, 0x6b,
-	0x65, 0x2e, 0x4e, 0x65, 0x78, 0x74, 0x49, 0x64, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65,
-	0x22, 0x00, 0x42, 0x3b, 0x5a, 0x39, 0x67, 0x69, 0x74, 0x2e, 0x73, 0x68, 0x69, 0x79, 0x6f, 0x75,
-	0x2e, 0x6b, 0x69, 0x6e, 0x67, 0x73, 0x6f, 0x66, 0x74, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x69, 0x6e,
-	0x66, 0x72, 0x61, 0x2f, 0x73, 0x6e, 0x6f, 0x77, 0x66, 0x6c, 0x61, 0x6b, 0x65, 0x2d, 0x73, 0x65,
-	0x72, 0x76, 0x69, 0x63, 0x65, 0x3b, 0x73, 0x6e, 0x6f, 0x77, 0x66, 0x6c, 0x61, 0x6b, 0x65, 0x62,
-	0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+	0x65, 0x2e, 0x4e, 0x65, 0x78, 0x74, 0x49, 0x64, 0x73, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73,
+	0x65, 0x22, 0x00, 0x12, 0x5a, 0x0a, 0x09, 0x53, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x49, 0x64, 0x73,
+	0x12, 0x22, 0x2e, 0x73, 0x65, 0x61, 0x79, 0x6f, 0x6f, 0x2e, 0x73, 0x6e, 0x6f, 0x77, 0x66, 0x6c,
+	0x61, 0x6b, 0x65, 0x2e, 0x53, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x49, 0x64, 0x73, 0x52, 0x65, 0x71,
+	0x75, 0x65, 0x73, 0x74, 0x1a, 0x23, 0x2e, 0x73, 0x65, 0x61, 0x79, 0x6f, 0x6f, 0x2e, 0x73, 0x6e,
+	0x6f, 0x77, 0x66, 0x6c, 0x61, 0x6b, 0x65, 0x2e, 0x53, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x49, 0x64,
+	0x73, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x22, 0x00, 0x28, 0x01, 0x30, 0x01, 0x12,
+	0x50, 0x0a, 0x07, 0x50, 0x61, 0x72, 0x73, 0x65, 0x49, 0x64, 0x12, 0x20, 0x2e, 0x73, 0x65, 0x61,
+	0x79, 0x6f, 0x6f, 0x2e, 0x73, 0x6e, 0x6f, 0x77, 0x66, 0x6c, 0x61, 0x6b, 0x65, 0x2e, 0x50, 0x61,
+	0x72, 0x73, 0x65, 0x49, 0x64, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x21, 0x2e, 0x73,
+	0x65, 0x61, 0x79, 0x6f, 0x6f, 0x2e, 0x73, 0x6e, 0x6f, 0x77, 0x66, 0x6c, 0x61, 0x6b, 0x65, 0x2e,
+	0x50, 0x61, 0x72, 0x73, 0x65, 0x49, 0x64, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x22,
+	0x00, 0x42, 0x3b, 0x5a, 0x39, 0x67, 0x69, 0x74, 0x2e, 0x73, 0x68, 0x69, 0x79, 0x6f, 0x75, 0x2e,
+	0x6b, 0x69, 0x6e, 0x67, 0x73, 0x6f, 0x66, 0x74, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x69, 0x6e, 0x66,
+	0x72, 0x61, 0x2f, 0x73, 0x6e, 0x6f, 0x77, 0x66, 0x6c, 0x61, 0x6b, 0x65, 0x2d, 0x73, 0x65, 0x72,
+	0x76, 0x69, 0x63, 0x65, 0x3b, 0x73, 0x6e, 0x6f, 0x77, 0x66, 0x6c, 0x61, 0x6b, 0x65, 0x62, 0x06,
+	0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
 }
 
 var (
@@ -138,19 +519,33 @@ func file_snowflake_proto_rawDescGZIP() []byte {
 	return file_snowflake_proto_rawDescData
 }
 
-var file_snowflake_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_snowflake_proto_msgTypes = make([]protoimpl.MessageInfo, 8)
 var file_snowflake_proto_goTypes = []interface{}{
-	(*NextIdRequest)(nil),  // 0: seayoo.snowflake.NextIdRequest
-	(*NextIdResponse)(nil), // 1: seayoo.snowflake.NextIdResponse
+	(*NextIdRequest)(nil),         // 0: seayoo.snowflake.NextIdRequest
+	(*NextIdResponse)(nil),        // 1: seayoo.snowflake.NextIdResponse
+	(*NextIdsRequest)(nil),        // 2: seayoo.snowflake.NextIdsRequest
+	(*NextIdsResponse)(nil),       // 3: seayoo.snowflake.NextIdsResponse
+	(*StreamIdsRequest)(nil),      // 4: seayoo.snowflake.StreamIdsRequest
+	(*StreamIdsResponse)(nil),     // 5: seayoo.snowflake.StreamIdsResponse
+	(*ParseIdRequest)(nil),        // 6: seayoo.snowflake.ParseIdRequest
+	(*ParseIdResponse)(nil),       // 7: seayoo.snowflake.ParseIdResponse
+	(*timestamppb.Timestamp)(nil), // 8: google.protobuf.Timestamp
 }
 var file_snowflake_proto_depIdxs = []int32{
-	0, // 0: seayoo.snowflake.Snowflake.NextId:input_type -> seayoo.snowflake.NextIdRequest
-	1, // 1: seayoo.snowflake.Snowflake.NextId:output_type -> seayoo.snowflake.NextIdResponse
-	1, // [1:2] is the sub-list for method output_type
-	0, // [0:1] is the sub-list for method input_type
-	0, // [0:0] is the sub-list for extension type_name
-	0, // [0:0] is the sub-list for extension extendee
-	0, // [0:0] is the sub-list for field type_name
+	8, // 0: seayoo.snowflake.ParseIdResponse.time:type_name -> google.protobuf.Timestamp
+	0, // 1: seayoo.snowflake.Snowflake.NextId:input_type -> seayoo.snowflake.NextIdRequest
+	2, // 2: seayoo.snowflake.Snowflake.NextIds:input_type -> seayoo.snowflake.NextIdsRequest
+	4, // 3: seayoo.snowflake.Snowflake.StreamIds:input_type -> seayoo.snowflake.StreamIdsRequest
+	6, // 4: seayoo.snowflake.Snowflake.ParseId:input_type -> seayoo.snowflake.ParseIdRequest
+	1, // 5: seayoo.snowflake.Snowflake.NextId:output_type -> seayoo.snowflake.NextIdResponse
+	3, // 6: seayoo.snowflake.Snowflake.NextIds:output_type -> seayoo.snowflake.NextIdsResponse
+	5, // 7: seayoo.snowflake.Snowflake.StreamIds:output_type -> seayoo.snowflake.StreamIdsResponse
+	7, // 8: seayoo.snowflake.Snowflake.ParseId:output_type -> seayoo.snowflake.ParseIdResponse
+	5, // [5:9] is the sub-list for method output_type
+	1, // [1:5] is the sub-list for method input_type
+	1, // [1:1] is the sub-list for extension type_name
+	1, // [1:1] is the sub-list for extension extendee
+	0, // [0:1] is the sub-list for field type_name
 }
 
 func init() { file_snowflake_proto_init() }
@@ -183,6 +578,78 @@ func file_snowflake_proto_init() {
 				return nil
 			}
 		}
+		file_snowflake_proto_msgTypes[2].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*NextIdsRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_snowflake_proto_msgTypes[3].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*NextIdsResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_snowflake_proto_msgTypes[4].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*StreamIdsRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_snowflake_proto_msgTypes[5].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*StreamIdsResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_snowflake_proto_msgTypes[6].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ParseIdRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_snowflake_proto_msgTypes[7].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ParseIdResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
 	}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
@@ -190,7 +657,7 @@ func file_snowflake_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: file_snowflake_proto_rawDesc,
 			NumEnums:      0,
-			NumMessages:   2,
+			NumMessages:   8,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
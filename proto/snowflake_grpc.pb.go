@@ -23,6 +23,17 @@ const _ = grpc.SupportPackageIsVersion7
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type SnowflakeClient interface {
 	NextId(ctx context.Context, in *NextIdRequest, opts ...grpc.CallOption) (*NextIdResponse, error)
+	// NextIds returns count ids whose sequences are reserved contiguously in a
+	// single atomic update, continuing into the next milliseconds if needed,
+	// count must be between 1 and the server side max batch size.
+	NextIds(ctx context.Context, in *NextIdsRequest, opts ...grpc.CallOption) (*NextIdsResponse, error)
+	// StreamIds pushes ids to the client in chunks. The client drives the flow
+	// control by sending credits, the server never sends more ids than the
+	// credits granted so far.
+	StreamIds(ctx context.Context, opts ...grpc.CallOption) (Snowflake_StreamIdsClient, error)
+	// ParseId decodes an id with the layout and epoch the server is configured
+	// with, ids whose timestamp lies in the future are rejected.
+	ParseId(ctx context.Context, in *ParseIdRequest, opts ...grpc.CallOption) (*ParseIdResponse, error)
 }
 
 type snowflakeClient struct {
@@ -42,11 +53,71 @@ func (c *snowflakeClient) NextId(ctx context.Context, in *NextIdRequest, opts ..
 	return out, nil
 }
 
+func (c *snowflakeClient) NextIds(ctx context.Context, in *NextIdsRequest, opts ...grpc.CallOption) (*NextIdsResponse, error) {
+	out := new(NextIdsResponse)
+	err := c.cc.Invoke(ctx, "/seayoo.snowflake.Snowflake/NextIds", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *snowflakeClient) StreamIds(ctx context.Context, opts ...grpc.CallOption) (Snowflake_StreamIdsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Snowflake_ServiceDesc.Streams[0], "/seayoo.snowflake.Snowflake/StreamIds", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &snowflakeStreamIdsClient{stream}
+	return x, nil
+}
+
+type Snowflake_StreamIdsClient interface {
+	Send(*StreamIdsRequest) error
+	Recv() (*StreamIdsResponse, error)
+	grpc.ClientStream
+}
+
+type snowflakeStreamIdsClient struct {
+	grpc.ClientStream
+}
+
+func (x *snowflakeStreamIdsClient) Send(m *StreamIdsRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *snowflakeStreamIdsClient) Recv() (*StreamIdsResponse, error) {
+	m := new(StreamIdsResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *snowflakeClient) ParseId(ctx context.Context, in *ParseIdRequest, opts ...grpc.CallOption) (*ParseIdResponse, error) {
+	out := new(ParseIdResponse)
+	err := c.cc.Invoke(ctx, "/seayoo.snowflake.Snowflake/ParseId", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // SnowflakeServer is the server API for Snowflake service.
 // All implementations should embed UnimplementedSnowflakeServer
 // for forward compatibility
 type SnowflakeServer interface {
 	NextId(context.Context, *NextIdRequest) (*NextIdResponse, error)
+	// NextIds returns count ids whose sequences are reserved contiguously in a
+	// single atomic update, continuing into the next milliseconds if needed,
+	// count must be between 1 and the server side max batch size.
+	NextIds(context.Context, *NextIdsRequest) (*NextIdsResponse, error)
+	// StreamIds pushes ids to the client in chunks. The client drives the flow
+	// control by sending credits, the server never sends more ids than the
+	// credits granted so far.
+	StreamIds(Snowflake_StreamIdsServer) error
+	// ParseId decodes an id with the layout and epoch the server is configured
+	// with, ids whose timestamp lies in the future are rejected.
+	ParseId(context.Context, *ParseIdRequest) (*ParseIdResponse, error)
 }
 
 // UnimplementedSnowflakeServer should be embedded to have forward compatible implementations.
@@ -56,6 +127,15 @@ type UnimplementedSnowflakeServer struct {
 func (UnimplementedSnowflakeServer) NextId(context.Context, *NextIdRequest) (*NextIdResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method NextId not implemented")
 }
+func (UnimplementedSnowflakeServer) NextIds(context.Context, *NextIdsRequest) (*NextIdsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method NextIds not implemented")
+}
+func (UnimplementedSnowflakeServer) StreamIds(Snowflake_StreamIdsServer) error {
+	return status.Errorf(codes.Unimplemented, "method StreamIds not implemented")
+}
+func (UnimplementedSnowflakeServer) ParseId(context.Context, *ParseIdRequest) (*ParseIdResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ParseId not implemented")
+}
 
 // UnsafeSnowflakeServer may be embedded to opt out of forward compatibility for this service.
 // Use of this interface is not recommended, as added methods to SnowflakeServer will
@@ -86,6 +166,68 @@ func _Snowflake_NextId_Handler(srv interface{}, ctx context.Context, dec func(in
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Snowflake_NextIds_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(NextIdsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SnowflakeServer).NextIds(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/seayoo.snowflake.Snowflake/NextIds",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SnowflakeServer).NextIds(ctx, req.(*NextIdsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Snowflake_StreamIds_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(SnowflakeServer).StreamIds(&snowflakeStreamIdsServer{stream})
+}
+
+type Snowflake_StreamIdsServer interface {
+	Send(*StreamIdsResponse) error
+	Recv() (*StreamIdsRequest, error)
+	grpc.ServerStream
+}
+
+type snowflakeStreamIdsServer struct {
+	grpc.ServerStream
+}
+
+func (x *snowflakeStreamIdsServer) Send(m *StreamIdsResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *snowflakeStreamIdsServer) Recv() (*StreamIdsRequest, error) {
+	m := new(StreamIdsRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _Snowflake_ParseId_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ParseIdRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SnowflakeServer).ParseId(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/seayoo.snowflake.Snowflake/ParseId",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SnowflakeServer).ParseId(ctx, req.(*ParseIdRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // Snowflake_ServiceDesc is the grpc.ServiceDesc for Snowflake service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -97,7 +239,22 @@ var Snowflake_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "NextId",
 			Handler:    _Snowflake_NextId_Handler,
 		},
+		{
+			MethodName: "NextIds",
+			Handler:    _Snowflake_NextIds_Handler,
+		},
+		{
+			MethodName: "ParseId",
+			Handler:    _Snowflake_ParseId_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamIds",
+			Handler:       _Snowflake_StreamIds_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "snowflake.proto",
 }
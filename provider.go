@@ -5,6 +5,7 @@ import (
 	"github.com/hashicorp/consul/api"
 	"log"
 	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -16,23 +17,144 @@ var consulProvider *ConsulProvider
 
 type Provider interface {
 	GetWorkerId() (int64, error)
+	// Subscribe registers a callback of the provider events, the latest event is replayed to the callback
+	Subscribe(func(ProviderEvent))
 	Stop()
 }
 
+type ProviderEventType int
+
+const (
+	ProviderAcquiring      ProviderEventType = iota // start acquiring a worker id
+	ProviderAcquired                                // a worker id is acquired
+	ProviderLost                                    // the acquired worker id is lost
+	ProviderSelfPreserving                          // the lost worker id is still used because of the self preservation
+	ProviderStopped                                 // the provider is stopped
+)
+
+func (t ProviderEventType) String() string {
+	switch t {
+	case ProviderAcquiring:
+		return "acquiring"
+	case ProviderAcquired:
+		return "acquired"
+	case ProviderLost:
+		return "lost"
+	case ProviderSelfPreserving:
+		return "self_preserving"
+	case ProviderStopped:
+		return "stopped"
+	}
+	return "unknown"
+}
+
+// ProviderEvent is a state change of a provider, WorkerId is the worker id acquired, lost or
+// self preserved, and the latest worker id for the others.
+type ProviderEvent struct {
+	Type     ProviderEventType
+	WorkerId int64
+}
+
+// eventBus is embedded by the providers to publish the provider events, the callbacks are called
+// synchronously in order so they must not block.
+type eventBus struct {
+	eventMutex  sync.Mutex
+	subscribers []func(ProviderEvent)
+	latest      *ProviderEvent
+	preserving  *time.Timer // publishes the lost event when the safe self preservation expires
+}
+
+func (b *eventBus) Subscribe(f func(ProviderEvent)) {
+	b.eventMutex.Lock()
+	defer b.eventMutex.Unlock()
+	b.subscribers = append(b.subscribers, f)
+	if b.latest != nil {
+		f(*b.latest)
+	}
+}
+
+func (b *eventBus) publish(t ProviderEventType, workerId int64) {
+	b.eventMutex.Lock()
+	defer b.eventMutex.Unlock()
+	b.emit(t, workerId)
+}
+
+// emit calls the subscribers with the eventMutex held, the pending expiry of the self preservation is
+// cancelled once the worker id is acquired, lost or stopped.
+func (b *eventBus) emit(t ProviderEventType, workerId int64) {
+	if b.preserving != nil && t != ProviderAcquiring {
+		b.preserving.Stop()
+		b.preserving = nil
+	}
+	event := ProviderEvent{Type: t, WorkerId: workerId}
+	b.latest = &event
+	for _, f := range b.subscribers {
+		f(event)
+	}
+}
+
+// publishLost publishes the lost event, followed by the self preserving event if the self preservation
+// is enabled and not expired at preserveUntil, zero preserveUntil means no limit. The lost event is
+// published again once the self preservation expires, unless the worker id is acquired before that.
+func (b *eventBus) publishLost(workerId int64, enableSelfPreservation bool, preserveUntil time.Time) {
+	b.eventMutex.Lock()
+	defer b.eventMutex.Unlock()
+	b.emit(ProviderLost, workerId)
+	if !enableSelfPreservation {
+		return
+	}
+	if preserveUntil.IsZero() {
+		b.emit(ProviderSelfPreserving, workerId)
+		return
+	}
+	d := time.Until(preserveUntil)
+	if d <= 0 {
+		return
+	}
+	b.emit(ProviderSelfPreserving, workerId)
+	var timer *time.Timer
+	timer = time.AfterFunc(d, func() {
+		b.eventMutex.Lock()
+		defer b.eventMutex.Unlock()
+		if b.preserving != timer {
+			return
+		}
+		b.preserving = nil
+		log.Printf("the self preservation of worker id %d expired", workerId)
+		b.emit(ProviderLost, workerId)
+	})
+	b.preserving = timer
+}
+
+// DatacenterProvider is implemented by the providers which can supply the
+// datacenter id of the worker, the datacenter id is 0 for the other providers.
+type DatacenterProvider interface {
+	GetDatacenterId() (int64, error)
+}
+
 type SimpleProvider struct {
-	workerId int64
+	eventBus
+	workerId     int64
+	datacenterId int64
 }
 
-func (p *SimpleProvider) Stop() {}
+func (p *SimpleProvider) Stop() {
+	p.publish(ProviderStopped, p.workerId)
+}
 
-func getSimpleProvider(workerId int64) *SimpleProvider {
+func getSimpleProvider(workerId int64, maxWorkerId int64, datacenterId int64, maxDatacenterId int64) *SimpleProvider {
 	if workerId < 0 || workerId > maxWorkerId {
 		log.Fatalf("workerId must between 0 and %d", maxWorkerId)
 	}
+	if datacenterId < 0 || datacenterId > maxDatacenterId {
+		log.Fatalf("datacenterId must between 0 and %d", maxDatacenterId)
+	}
 	once.Do(func() {
 		simpleProvider = &SimpleProvider{
-			workerId: workerId,
+			workerId:     workerId,
+			datacenterId: datacenterId,
 		}
+		simpleProvider.publish(ProviderAcquired, workerId)
 	})
 	return simpleProvider
 }
@@ -41,34 +163,151 @@ func (p *SimpleProvider) GetWorkerId() (int64, error) {
 	return p.workerId, nil
 }
 
-func getConsulProvider(address string, keyPrefix string, hintWorkerId int64, enableSelfPreservation bool) *ConsulProvider {
-	if hintWorkerId < 0 || hintWorkerId > maxWorkerId {
-		log.Printf("hint-worker-id must between 0 and %d, use default 0\n", maxWorkerId)
-		hintWorkerId = 0
+func (p *SimpleProvider) GetDatacenterId() (int64, error) {
+	return p.datacenterId, nil
+}
+
+// ConsulClientConfig is the settings to connect to the consul agent, the empty settings fall back to
+// the CONSUL_* environment variables read by the consul api.
+type ConsulClientConfig struct {
+	Address       string
+	Scheme        string // http or https
+	Token         string // ACL token
+	TokenFile     string // file containing the ACL token
+	CAFile        string
+	CertFile      string
+	KeyFile       string
+	TLSServerName string
+	Datacenter    string
+	Namespace     string // enterprise only
+	Partition     string // enterprise only
+}
+
+func (c ConsulClientConfig) apiConfig() *api.Config {
+	config := api.DefaultConfig()
+	set := func(dst *string, value string) {
+		if value != "" {
+			*dst = value
+		}
+	}
+	set(&config.Address, c.Address)
+	set(&config.Scheme, c.Scheme)
+	set(&config.Token, c.Token)
+	set(&config.TokenFile, c.TokenFile)
+	set(&config.TLSConfig.CAFile, c.CAFile)
+	set(&config.TLSConfig.CertFile, c.CertFile)
+	set(&config.TLSConfig.KeyFile, c.KeyFile)
+	set(&config.TLSConfig.Address, c.TLSServerName)
+	set(&config.Datacenter, c.Datacenter)
+	set(&config.Namespace, c.Namespace)
+	set(&config.Partition, c.Partition)
+	return config
+}
+
+// checkConsul checks the consul agent is reachable and the token is permitted to create sessions and
+// read the keys, so a misconfiguration fails the startup instead of retrying forever in start.
+func checkConsul(c *api.Client, config *api.Config, keyPrefix string) error {
+	if _, err := c.Status().Leader(); err != nil {
+		hint := fmt.Sprintf("check consul-address (%s://%s) is reachable", config.Scheme, config.Address)
+		if strings.Contains(err.Error(), "x509") || strings.Contains(err.Error(), "tls") || strings.Contains(err.Error(), "HTTP response to HTTPS client") {
+			hint = "check consul-scheme, consul-ca-file, consul-cert-file, consul-key-file and consul-tls-server-name"
+		}
+		return fmt.Errorf("connect to consul error: %w, %s", err, hint)
+	}
+	id, _, err := c.Session().CreateNoChecks(&api.SessionEntry{Name: "snowflake connectivity check", TTL: "10s"}, nil)
+	if err != nil {
+		return fmt.Errorf("create consul session error: %w, check the ACL token (consul-token or consul-token-file) has session:write on the node", err)
+	}
+	if _, err := c.Session().Destroy(id, nil); err != nil {
+		log.Printf("destroy consul session %s error %v", id, err)
 	}
+	if _, _, err := c.KV().List(keyPrefix, nil); err != nil {
+		return fmt.Errorf("read consul key %s error: %w, check the ACL token (consul-token or consul-token-file) has key:write on the consul-key-prefix"+
+			" and consul-namespace/consul-partition are right", keyPrefix, err)
+	}
+	return nil
+}
+
+// ConsulSessionConfig is the settings of the consul session the worker id lock is bound to
+type ConsulSessionConfig struct {
+	TTL           time.Duration // the session is invalidated if it is not renewed within the TTL
+	LockDelay     time.Duration // others can not acquire the lock within the lock delay after the session is invalidated
+	Behavior      string        // release or delete the lock key when the session is invalidated
+	NodeChecks    []string      // the node checks, e.g. serfHealth, the session is invalidated once any check is critical
+	ServiceChecks []string      // the service checks the session is invalidated once any check is critical
+}
+
+// Validate checks the settings are accepted by consul
+func (c ConsulSessionConfig) Validate() error {
+	if c.TTL < 10*time.Second || c.TTL > 24*time.Hour {
+		return fmt.Errorf("session TTL %s must between 10s and 24h", c.TTL)
+	}
+	if c.LockDelay < time.Millisecond || c.LockDelay > time.Minute {
+		return fmt.Errorf("lock delay %s must between 1ms and 1m", c.LockDelay)
+	}
+	if c.Behavior != api.SessionBehaviorRelease && c.Behavior != api.SessionBehaviorDelete {
+		return fmt.Errorf("session behavior %q must be %s or %s", c.Behavior, api.SessionBehaviorRelease, api.SessionBehaviorDelete)
+	}
+	return nil
+}
+
+// FailoverTime returns how long at most others can acquire the worker id after the instance is gone,
+// consul invalidates the session no later than twice the TTL, and then the lock delay applies.
+func (c ConsulSessionConfig) FailoverTime() time.Duration {
+	return 2*c.TTL + c.LockDelay
+}
+
+func getConsulProvider(client ConsulClientConfig, keyPrefix string, hintWorkerId int64, maxWorkerId int64, datacenterMapping map[string]int64, session ConsulSessionConfig, service *ConsulServiceConfig, enableSelfPreservation bool, safeSelfPreservation bool) *ConsulProvider {
 	once.Do(func() {
-		leaderCh := make(chan struct{}, 1)
-		leaderCh <- struct{}{} // used by start
-		workerId := atomic.Value{}
-		workerId.Store(hintWorkerId)
-		state := atomic.Value{}
-		state.Store(unavailable)
-		config := api.DefaultConfig()
-		config.Address = address
+		config := client.apiConfig()
 		c, err := api.NewClient(config)
 		if err != nil {
 			log.Fatalf("New consul api client error: %v", err)
 		}
+		if err := checkConsul(c, config, keyPrefix); err != nil {
+			log.Fatalf("Consul is unavailable: %v", err)
+		}
+		var datacenterId int64
+		if len(datacenterMapping) > 0 {
+			datacenter, err := agentDatacenter(c)
+			if err != nil {
+				log.Fatalf("Get the datacenter of the consul agent error: %v", err)
+			}
+			id, ok := datacenterMapping[datacenter]
+			if !ok {
+				log.Fatalf("The datacenter %q of the consul agent is not in the consul-datacenter-mapping", datacenter)
+			}
+			log.Printf("consul agent datacenter %q is mapped to datacenter id %d", datacenter, id)
+			datacenterId = id
+		}
+		if err := session.Validate(); err != nil {
+			log.Fatalf("Invalid consul session: %v", err)
+		}
 		consulProvider = &ConsulProvider{
-			keyPrefix:              keyPrefix,
-			workerId:               workerId,
-			leaderCh:               leaderCh,
-			stopCh:                 make(chan struct{}),
-			state:                  state,
-			enableSelfPreservation: enableSelfPreservation,
-			consul:                 c,
-		}
-		go consulProvider.start()
+			keyPrefix:    keyPrefix,
+			session:      session,
+			datacenterId: datacenterId,
+			consul:       c,
+		}
+		consulProvider.init("consul", hintWorkerId, maxWorkerId, enableSelfPreservation, safeSelfPreservation)
+		if service != nil {
+			// the session is bound to the liveness check, so an unhealthy instance loses its worker id
+			check, err := consulProvider.RegisterService(*service)
+			if err != nil {
+				log.Fatalf("Register the service in consul error: %v", err)
+			}
+			consulProvider.session.ServiceChecks = append(consulProvider.session.ServiceChecks, check)
+		}
+		session = consulProvider.session
+		checkFailover := fmt.Sprintf("%s (lock-delay) after a check turns critical", session.LockDelay)
+		if service != nil {
+			checkFailover = fmt.Sprintf("%s (%d * consul-check-interval + lock-delay) after the liveness check starts failing",
+				livenessFailuresBeforeCritical*service.CheckInterval+session.LockDelay, livenessFailuresBeforeCritical)
+		}
+		log.Printf("consul session ttl=%s, lock-delay=%s, behavior=%s, node checks=%v, service checks=%v, "+
+			"a lost worker id can be acquired by others after %s (2 * ttl + lock-delay) at most, or %s",
+			session.TTL, session.LockDelay, session.Behavior, session.NodeChecks, session.ServiceChecks, session.FailoverTime(), checkFailover)
+		go consulProvider.acquire(consulProvider.tryLock, consulProvider.destroySession)
 	})
 	return consulProvider
 }
@@ -80,72 +319,122 @@ const (
 	available   = state(1)
 )
 
-type ConsulProvider struct {
-	sync.Mutex
-	lock                   *api.Lock
-	leaderCh               <-chan struct{}
+// lockHolder is embedded by the providers which hold the worker id with a lock, it runs the
+// acquisition loop and returns the worker id with the self preservation once the lock is lost.
+type lockHolder struct {
+	eventBus
+	name                   string
 	stopCh                 chan struct{}
 	workerId               atomic.Value
-	keyPrefix              string
+	maxWorkerId            int64
 	state                  atomic.Value
 	enableSelfPreservation bool
-	consul                 *api.Client
+	safeSelfPreservation   bool
+	preserveUntil          atomic.Value
+	validUntil             atomic.Value // until when the lock is valid if it expires without renewal, zero if unknown
 }
 
-func (p *ConsulProvider) GetWorkerId() (int64, error) {
-	if p.state.Load() == unavailable {
-		if !p.enableSelfPreservation {
-			log.Printf("Fatal: enable-self-preservation=%v, consul provider is unavailable!!!", p.enableSelfPreservation)
-			return 0, fmt.Errorf("consulProvider is unavailable")
+// init sets up the lockHolder before the acquisition starts, the hint worker id is tried first
+func (h *lockHolder) init(name string, hintWorkerId int64, maxWorkerId int64, enableSelfPreservation bool, safeSelfPreservation bool) {
+	if hintWorkerId < 0 || hintWorkerId > maxWorkerId {
+		log.Printf("hint-worker-id must between 0 and %d, use default 0\n", maxWorkerId)
+		hintWorkerId = 0
+	}
+	h.name = name
+	h.stopCh = make(chan struct{})
+	h.maxWorkerId = maxWorkerId
+	h.enableSelfPreservation = enableSelfPreservation
+	h.safeSelfPreservation = safeSelfPreservation
+	h.workerId.Store(hintWorkerId)
+	h.preserveUntil.Store(preservationDeadline(safeSelfPreservation, 0))
+	h.validUntil.Store(time.Time{})
+	h.state.Store(unavailable)
+}
+
+// GetWorkerId returns the worker id held with the lock, if the lock is lost the latest worker id is
+// used when the self preservation is enabled, until the time in preserveUntil if it is not zero. The
+// lock is treated as lost once validUntil passes, even if the loss is not detected yet.
+func (h *lockHolder) GetWorkerId() (int64, error) {
+	state, until := h.state.Load(), h.preserveUntil.Load().(time.Time)
+	if validUntil := h.validUntil.Load().(time.Time); state == available && !validUntil.IsZero() && time.Now().After(validUntil) {
+		// others may claim the worker id already, no grace period for the safe self preservation
+		state = unavailable
+		if h.safeSelfPreservation {
+			until = validUntil
+		}
+	}
+	if state == unavailable {
+		if !h.enableSelfPreservation {
+			log.Printf("Fatal: enable-self-preservation=%v, %s provider is unavailable!!!", h.enableSelfPreservation, h.name)
+			return 0, fmt.Errorf("%sProvider is unavailable", h.name)
+		} else if !until.IsZero() && time.Now().After(until) {
+			log.Printf("Fatal: safe-self-preservation=true, %s provider is unavailable since %s!!!", h.name, until.Format(time.RFC3339Nano))
+			return 0, fmt.Errorf("%sProvider is unavailable and the self preservation expired", h.name)
 		} else {
-			workerId := p.workerId.Load().(int64)
-			log.Printf("Warnning: enable-self-preservation=%v, consul provider is unavailable, use worker id: %d", p.enableSelfPreservation, workerId)
-			return workerId, nil
+			id := h.workerId.Load().(int64)
+			log.Printf("Warnning: enable-self-preservation=%v, %s provider is unavailable, use worker id: %d", h.enableSelfPreservation, h.name, id)
+			return id, nil
 		}
 	}
-	return p.workerId.Load().(int64), nil
+	return h.workerId.Load().(int64), nil
 }
 
-func (p *ConsulProvider) start() {
+// acquire holds a worker id until stopCh is closed. Starting from the latest worker id, the worker ids
+// are tried in turn with tryLock, which returns a channel closed once the lock is lost and the grace
+// period of the safe self preservation after the loss. release is called after the lock is lost.
+func (h *lockHolder) acquire(tryLock func(workerId int64) (<-chan struct{}, time.Duration, error), release func()) {
+	workerId := h.workerId.Load().(int64)
 	for {
+		h.publish(ProviderAcquiring, workerId)
+		var lostCh <-chan struct{}
+		var grace time.Duration
+		for {
+			log.Printf("start accquire worker id: %d", workerId)
+			ch, g, err := tryLock(workerId)
+			if err == nil {
+				lostCh, grace = ch, g
+				break
+			}
+			log.Printf("accquire worker id %d error %v", workerId, err)
+			workerId = roundNext(workerId, h.maxWorkerId)
+			select {
+			case <-h.stopCh:
+				return
+			case <-time.After(3 * time.Second):
+			}
+		}
+		h.workerId.Store(workerId)
+		h.state.Store(available)
+		h.publish(ProviderAcquired, workerId)
+		log.Printf("accquire worker id success: %d", workerId)
 		select {
-		case <-p.stopCh:
+		case <-h.stopCh:
 			return
-		case <-p.leaderCh:
-			p.state.Store(unavailable)
-			workerId := roundPre(p.workerId.Load().(int64), maxWorkerId)
-			var i int64
-			for i = 0; i <= maxWorkerId; i++ {
-				workerId = roundNext(workerId, maxWorkerId)
-				log.Printf("start accquire worker id: %d", workerId)
-				lockOptions := &api.LockOptions{
-					Key:          p.keyPrefix + strconv.FormatInt(workerId, 10),
-					LockTryOnce:  true,
-					LockWaitTime: time.Millisecond,
-				}
-				lock, _ := p.consul.LockOpts(lockOptions)
-				ch, err := lock.Lock(p.stopCh)
-				if ch != nil && err == nil {
-					p.leaderCh = ch
-					p.workerId.Store(workerId)
-					p.state.Store(available)
-					p.lock = lock
-					log.Printf("accquire worker id success: %d", workerId)
-					break
-				} else {
-					log.Printf("accquire worker id %d error %v", workerId, err)
-					if workerId == maxWorkerId {
-						leaderCh := make(chan struct{}, 1)
-						leaderCh <- struct{}{}
-						p.leaderCh = leaderCh
-					}
-				}
-				time.Sleep(3 * time.Second)
-			}
+		case <-lostCh:
 		}
+		h.preserveUntil.Store(preservationDeadline(h.safeSelfPreservation, grace))
+		h.state.Store(unavailable)
+		release()
+		h.publishLost(workerId, h.enableSelfPreservation, h.preserveUntil.Load().(time.Time))
 	}
 }
 
+type ConsulProvider struct {
+	sync.Mutex
+	lockHolder
+	lock          *api.Lock
+	sessionDoneCh chan struct{}
+	serviceId     string
+	session       ConsulSessionConfig
+	datacenterId  int64
+	keyPrefix     string
+	consul        *api.Client
+}
+
+func (p *ConsulProvider) GetDatacenterId() (int64, error) {
+	return p.datacenterId, nil
+}
+
 func (p *ConsulProvider) Stop() {
 	defer func() {
 		if err := recover(); err != nil {
@@ -154,9 +443,143 @@ func (p *ConsulProvider) Stop() {
 	}()
 	log.Printf("ConsulProvider stop, release worker id: %d", p.workerId)
 	close(p.stopCh)
+	defer p.publish(ProviderStopped, p.workerId.Load().(int64))
+	defer p.destroySession()
+	p.Lock()
+	defer p.Unlock()
 	p.lock.Unlock()
 }
 
+// tryLock creates a session and tries once to lock the worker id with it, the session is destroyed
+// if the lock is not acquired. The grace period of the safe self preservation depends on the session.
+func (p *ConsulProvider) tryLock(workerId int64) (<-chan struct{}, time.Duration, error) {
+	entry := &api.SessionEntry{
+		Name:      "snowflake worker id " + strconv.FormatInt(workerId, 10),
+		TTL:       p.session.TTL.String(),
+		LockDelay: p.session.LockDelay,
+		Behavior:  p.session.Behavior,
+	}
+	var id string
+	var err error
+	if len(p.session.NodeChecks) == 0 && len(p.session.ServiceChecks) == 0 {
+		id, _, err = p.consul.Session().CreateNoChecks(entry, nil)
+	} else {
+		entry.NodeChecks = p.session.NodeChecks
+		for _, check := range p.session.ServiceChecks {
+			entry.ServiceChecks = append(entry.ServiceChecks, api.ServiceCheck{ID: check})
+		}
+		id, _, err = p.consul.Session().Create(entry, nil)
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("create session error: %w", err)
+	}
+	doneCh := make(chan struct{})
+	go func() {
+		// RenewPeriodic destroys the session once doneCh is closed
+		if err := p.consul.Session().RenewPeriodic(entry.TTL, id, nil, doneCh); err != nil {
+			log.Printf("renew consul session %s error %v", id, err)
+		}
+	}()
+	lock, err := p.consul.LockOpts(&api.LockOptions{
+		Key:          p.keyPrefix + strconv.FormatInt(workerId, 10),
+		Session:      id,
+		LockTryOnce:  true,
+		LockWaitTime: time.Millisecond,
+	})
+	if err != nil {
+		close(doneCh)
+		return nil, 0, err
+	}
+	ch, err := lock.Lock(p.stopCh)
+	if err != nil {
+		close(doneCh)
+		return nil, 0, err
+	}
+	if ch == nil {
+		close(doneCh)
+		return nil, 0, fmt.Errorf("worker id %d is held by others", workerId)
+	}
+	p.Lock()
+	p.lock = lock
+	p.sessionDoneCh = doneCh
+	p.Unlock()
+	return ch, p.sessionGrace(id), nil
+}
+
+// destroySession stops renewing and destroys the session of the lost or released lock
+func (p *ConsulProvider) destroySession() {
+	p.Lock()
+	defer p.Unlock()
+	if p.sessionDoneCh != nil {
+		close(p.sessionDoneCh)
+		p.sessionDoneCh = nil
+	}
+}
+
+// sessionGrace returns how long the lost worker id can be used in the safe self preservation after the
+// loss is detected, which is half of the lock delay of the session holding the lock. The session may be
+// invalidated just before the loss is detected, and the session is destroyed on detection, so others can
+// acquire the lock once the lock delay passes; the other half leaves a margin for the detection delay.
+func (p *ConsulProvider) sessionGrace(id string) time.Duration {
+	entry, _, err := p.consul.Session().Info(id, nil)
+	if err != nil || entry == nil {
+		log.Printf("get the session %s error %v, no grace period for the safe self preservation", id, err)
+		return 0
+	}
+	return entry.LockDelay / 2
+}
+
+// agentDatacenter returns the datacenter the consul agent belongs to
+func agentDatacenter(c *api.Client) (string, error) {
+	self, err := c.Agent().Self()
+	if err != nil {
+		return "", err
+	}
+	datacenter, ok := self["Config"]["Datacenter"].(string)
+	if !ok {
+		return "", fmt.Errorf("datacenter is missing in the agent config")
+	}
+	return datacenter, nil
+}
+
+// parseDatacenterMapping parses the mapping from consul datacenter to datacenter id, e.g. "dc1=0,dc2=1"
+func parseDatacenterMapping(value string, maxDatacenterId int64) (map[string]int64, error) {
+	mapping := make(map[string]int64)
+	if value == "" {
+		return mapping, nil
+	}
+	used := make(map[int64]string)
+	for _, pair := range strings.Split(value, ",") {
+		kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("invalid mapping %q, must be datacenter=id", pair)
+		}
+		id, err := strconv.ParseInt(kv[1], 10, 64)
+		if err != nil || id < 0 || id > maxDatacenterId {
+			return nil, fmt.Errorf("datacenter id of %q must between 0 and %d", kv[0], maxDatacenterId)
+		}
+		if other, ok := used[id]; ok {
+			return nil, fmt.Errorf("datacenter %q and %q are mapped to the same id %d", other, kv[0], id)
+		}
+		if _, ok := mapping[kv[0]]; ok {
+			return nil, fmt.Errorf("datacenter %q is mapped more than once", kv[0])
+		}
+		used[id] = kv[0]
+		mapping[kv[0]] = id
+	}
+	return mapping, nil
+}
+
+// preservationDeadline returns until when a lost worker id can be used, the grace period must be
+// shorter than the time others could acquire the worker id. Zero time means no limit if it is not
+// the safe self preservation.
+func preservationDeadline(safeSelfPreservation bool, grace time.Duration) time.Time {
+	if !safeSelfPreservation {
+		return time.Time{}
+	}
+	return time.Now().Add(grace)
+}
+
 // roundNext get the next value with round-robin algorithm
 // Note: contains zero, e.g. pos = 3, max = 5, the result will be [4, 5, 0, 1, 2, 3, 4, 5, 0...]
 func roundNext(pos, max int64) int64 {
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// providerTimeout is long enough for a provider to try every worker id once, it sleeps 3s between the tries
+const providerTimeout = 20 * time.Second
+
+// waitWorkerId polls GetWorkerId until the provider holds a worker id, or until it does not if held is false
+func waitWorkerId(t *testing.T, p Provider, held bool) int64 {
+	t.Helper()
+	deadline := time.Now().Add(providerTimeout)
+	for {
+		workerId, err := p.GetWorkerId()
+		if (err == nil) == held {
+			return workerId
+		}
+		if time.Now().After(deadline) {
+			if held {
+				t.Fatalf("no worker id is acquired in %s, last error %v", providerTimeout, err)
+			}
+			t.Fatalf("worker id %d is still held after %s", workerId, providerTimeout)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// testAcquireLoseReacquire starts two providers competing for worker id 0 and 1, takes the worker id
+// away from the first one with lose, gives it back with restore and waits until it is acquired again.
+// The providers must not enable the self preservation, GetWorkerId returns an error once the id is lost.
+func testAcquireLoseReacquire(t *testing.T, newProvider func() Provider, lose func(workerId int64), restore func(workerId int64)) {
+	p1 := newProvider()
+	defer p1.Stop()
+	first := waitWorkerId(t, p1, true)
+
+	p2 := newProvider()
+	defer p2.Stop()
+	if second := waitWorkerId(t, p2, true); second == first {
+		t.Fatalf("both providers acquired worker id %d", first)
+	}
+
+	lose(first)
+	waitWorkerId(t, p1, false)
+	restore(first)
+	if workerId := waitWorkerId(t, p1, true); workerId != first {
+		t.Errorf("reacquired worker id %d, want %d", workerId, first)
+	}
+}
+
+func TestLockHolderValidUntil(t *testing.T) {
+	expired, valid := time.Now().Add(-time.Millisecond), time.Now().Add(time.Minute)
+	tests := []struct {
+		validUntil   time.Time
+		enable, safe bool
+		wantErr      bool
+	}{
+		{time.Time{}, false, false, false},
+		{valid, false, false, false},
+		{expired, false, false, true},
+		{expired, true, false, false},
+		{expired, true, true, true},
+	}
+	for _, tt := range tests {
+		var h lockHolder
+		h.init("test", 3, 7, tt.enable, tt.safe)
+		h.validUntil.Store(tt.validUntil)
+		h.state.Store(available)
+		got, err := h.GetWorkerId()
+		if (err != nil) != tt.wantErr || err == nil && got != 3 {
+			t.Errorf("GetWorkerId() with validUntil %s, self preservation %v, safe %v = %d, %v, want 3, error %v",
+				tt.validUntil.Format(time.RFC3339Nano), tt.enable, tt.safe, got, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+var redisProvider *RedisProvider
+
+// renewScript extends the TTL of the key only if it is still owned by the given owner
+var renewScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// releaseScript deletes the key only if it is still owned by the given owner
+var releaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+func getRedisProvider(options *redis.Options, keyPrefix string, ttl time.Duration, hintWorkerId int64, maxWorkerId int64, enableSelfPreservation bool, safeSelfPreservation bool) *RedisProvider {
+	once.Do(func() {
+		redisProvider = newRedisProvider(redis.NewClient(options), keyPrefix, ttl, hintWorkerId, maxWorkerId, enableSelfPreservation, safeSelfPreservation)
+	})
+	return redisProvider
+}
+
+// newRedisProvider creates a RedisProvider with the given client and starts acquiring a worker id,
+// the client can point to an in-process redis such as miniredis.
+func newRedisProvider(c *redis.Client, keyPrefix string, ttl time.Duration, hintWorkerId int64, maxWorkerId int64, enableSelfPreservation bool, safeSelfPreservation bool) *RedisProvider {
+	hostname, _ := os.Hostname()
+	p := &RedisProvider{
+		keyPrefix: keyPrefix,
+		ttl:       ttl,
+		owner:     fmt.Sprintf("%s-%d-%d", hostname, os.Getpid(), time.Now().UnixNano()),
+		redis:     c,
+	}
+	p.init("redis", hintWorkerId, maxWorkerId, enableSelfPreservation, safeSelfPreservation)
+	go p.acquire(p.tryLock, func() {})
+	return p
+}
+
+// RedisProvider acquires a worker id by SET NX PX on the key prefix + worker id, the key is renewed
+// by a heartbeat every ttl/3 and the worker id is treated as lost once the key is owned by others
+// or could not be renewed before it expires.
+type RedisProvider struct {
+	lockHolder
+	keyPrefix string
+	ttl       time.Duration
+	owner     string
+	redis     *redis.Client
+}
+
+// tryLock claims the worker id and keeps renewing it, the returned channel is closed once the claim is
+// lost. The worker id is owned by others or expired already then, so there is no grace period for the
+// safe self preservation.
+func (p *RedisProvider) tryLock(workerId int64) (<-chan struct{}, time.Duration, error) {
+	validUntil, err := p.lock(workerId)
+	if err != nil {
+		return nil, 0, err
+	}
+	p.validUntil.Store(validUntil)
+	lostCh := make(chan struct{})
+	go p.renew(workerId, validUntil, lostCh)
+	return lostCh, 0, nil
+}
+
+// lock claims the worker id and returns until when the claim is valid
+func (p *RedisProvider) lock(workerId int64) (time.Time, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	validUntil := time.Now().Add(p.ttl)
+	ok, err := p.redis.SetNX(ctx, p.key(workerId), p.owner, p.ttl).Result()
+	if err != nil {
+		return time.Time{}, err
+	}
+	if !ok {
+		return time.Time{}, fmt.Errorf("worker id %d is held by others", workerId)
+	}
+	return validUntil, nil
+}
+
+// renew extends the claim of the worker id periodically, lostCh is closed once the claim is lost
+func (p *RedisProvider) renew(workerId int64, validUntil time.Time, lostCh chan struct{}) {
+	ticker := time.NewTicker(p.ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stopCh:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), p.ttl/3)
+			sentAt := time.Now()
+			renewed, err := renewScript.Run(ctx, p.redis, []string{p.key(workerId)}, p.owner, p.ttl.Milliseconds()).Int64()
+			cancel()
+			switch {
+			case err == nil && renewed == 1:
+				validUntil = sentAt.Add(p.ttl)
+				p.validUntil.Store(validUntil)
+				continue
+			case err == nil:
+				log.Printf("worker id %d is owned by others, lost the worker id", workerId)
+			case time.Now().Before(validUntil):
+				log.Printf("renew worker id %d error %v", workerId, err)
+				continue
+			default:
+				log.Printf("renew worker id %d error %v, the worker id is expired", workerId, err)
+			}
+			close(lostCh)
+			return
+		}
+	}
+}
+
+func (p *RedisProvider) key(workerId int64) string {
+	return p.keyPrefix + strconv.FormatInt(workerId, 10)
+}
+
+func (p *RedisProvider) Stop() {
+	workerId := p.workerId.Load().(int64)
+	log.Printf("RedisProvider stop, release worker id: %d", workerId)
+	close(p.stopCh)
+	defer p.publish(ProviderStopped, p.workerId.Load().(int64))
+	if p.state.Load() == available {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+		if err := releaseScript.Run(ctx, p.redis, []string{p.key(workerId)}, p.owner).Err(); err != nil {
+			log.Println("release worker id err: ", err)
+		}
+	}
+	if err := p.redis.Close(); err != nil {
+		log.Println("close redis client err: ", err)
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+func TestRedisProvider(t *testing.T) {
+	mr := miniredis.RunT(t)
+	const keyPrefix = "snowflake:test:"
+
+	testAcquireLoseReacquire(t,
+		func() Provider {
+			c := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+			return newRedisProvider(c, keyPrefix, 300*time.Millisecond, 0, 1, false, false)
+		},
+		func(workerId int64) {
+			// another owner takes over the key, the next renewal finds it is not owned any more
+			if err := mr.Set(keyPrefix+strconv.FormatInt(workerId, 10), "other"); err != nil {
+				t.Fatalf("set the key of worker id %d error %v", workerId, err)
+			}
+		},
+		func(workerId int64) {
+			mr.Del(keyPrefix + strconv.FormatInt(workerId, 10))
+		},
+	)
+}
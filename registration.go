@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"github.com/hashicorp/consul/api"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ConsulServiceConfig is the settings to register the service in the consul catalog
+type ConsulServiceConfig struct {
+	Name                    string
+	Address                 string // the advertised address, the address of the agent node is used if empty
+	CheckHost               string // the host the agent dials for the health check, see serviceCheckHost
+	Port                    int
+	CheckInterval           time.Duration
+	DeregisterCriticalAfter time.Duration
+}
+
+// RegisterService registers the service in the consul catalog with a gRPC readiness check and a gRPC
+// liveness check, it must be called before the first session is created because the session is bound to
+// the liveness check whose id is returned. The service is registered again with the new tags every time a
+// worker id is acquired.
+func (p *ConsulProvider) RegisterService(service ConsulServiceConfig) (string, error) {
+	hostname, _ := os.Hostname()
+	p.Lock()
+	p.serviceId = fmt.Sprintf("%s-%s-%d", service.Name, hostname, service.Port)
+	p.Unlock()
+	if err := p.registerService(service, -1); err != nil {
+		return "", err
+	}
+	p.Subscribe(func(e ProviderEvent) {
+		if e.Type == ProviderAcquired {
+			// the events must not block, register asynchronously
+			go func() {
+				if err := p.registerService(service, e.WorkerId); err != nil {
+					log.Printf("register service error %v", err)
+				}
+			}()
+		}
+	})
+	return livenessCheckId(p.serviceId), nil
+}
+
+// livenessFailuresBeforeCritical is how many consecutive failures turn the liveness check critical,
+// a single failed check does not take the worker id away
+const livenessFailuresBeforeCritical = 3
+
+// livenessCheckId returns the id of the liveness check of the registered service
+func livenessCheckId(serviceId string) string {
+	return "service:" + serviceId + ":" + livenessServiceName
+}
+
+// registerService registers the service tagged with the worker id and the datacenter id, the tags are
+// omitted if the worker id is negative. It is skipped if the worker id is stale or the service is deregistered.
+//
+// The readiness check is critical while no worker id is held, so the service is never deregistered for it,
+// otherwise an instance waiting for a free worker id would lose the liveness check its session is bound to.
+// The liveness check starts passing because consul refuses to create a session bound to a critical check.
+func (p *ConsulProvider) registerService(service ConsulServiceConfig, workerId int64) error {
+	p.Lock()
+	defer p.Unlock()
+	if p.serviceId == "" || workerId >= 0 && p.workerId.Load().(int64) != workerId {
+		return nil
+	}
+	address := net.JoinHostPort(service.CheckHost, strconv.Itoa(service.Port))
+	registration := &api.AgentServiceRegistration{
+		ID:      p.serviceId,
+		Name:    service.Name,
+		Address: service.Address,
+		Port:    service.Port,
+		Checks: api.AgentServiceChecks{
+			{
+				CheckID:  "service:" + p.serviceId,
+				Name:     "gRPC health",
+				GRPC:     address,
+				Interval: service.CheckInterval.String(),
+				Timeout:  "3s",
+			},
+			{
+				CheckID:                        livenessCheckId(p.serviceId),
+				Name:                           "gRPC liveness",
+				GRPC:                           address + "/" + livenessServiceName,
+				Interval:                       service.CheckInterval.String(),
+				Timeout:                        "3s",
+				Status:                         api.HealthPassing,
+				FailuresBeforeCritical:         livenessFailuresBeforeCritical,
+				DeregisterCriticalServiceAfter: service.DeregisterCriticalAfter.String(),
+			},
+		},
+	}
+	if workerId >= 0 {
+		registration.Tags = []string{
+			"worker-id=" + strconv.FormatInt(workerId, 10),
+			"datacenter-id=" + strconv.FormatInt(p.datacenterId, 10),
+		}
+		registration.Meta = map[string]string{
+			"worker_id":     strconv.FormatInt(workerId, 10),
+			"datacenter_id": strconv.FormatInt(p.datacenterId, 10),
+		}
+	}
+	if err := p.consul.Agent().ServiceRegister(registration); err != nil {
+		return fmt.Errorf("register service %s error: %w", p.serviceId, err)
+	}
+	if workerId >= 0 {
+		log.Printf("register service %s success, worker id: %d, datacenter id: %d", p.serviceId, workerId, p.datacenterId)
+	} else {
+		log.Printf("register service %s success", p.serviceId)
+	}
+	return nil
+}
+
+// DeregisterService removes the service from the consul catalog, the service is not registered any more
+func (p *ConsulProvider) DeregisterService() {
+	p.Lock()
+	defer p.Unlock()
+	if p.serviceId == "" {
+		return
+	}
+	if err := p.consul.Agent().ServiceDeregister(p.serviceId); err != nil {
+		log.Printf("deregister service %s error %v", p.serviceId, err)
+	} else {
+		log.Printf("deregister service %s success", p.serviceId)
+	}
+	p.serviceId = ""
+}
+
+// serviceCheckHost returns the host the consul agent dials for the gRPC health check: the advertised
+// address if set, otherwise the listen host if it is a specific address, otherwise the loopback address
+// if the agent runs on the same host. An error is returned if the agent can not reach the service.
+func serviceCheckHost(advertised string, listenHost string, agentAddress string) (string, error) {
+	if advertised != "" {
+		return advertised, nil
+	}
+	local := isLocalAgent(agentAddress)
+	if ip := net.ParseIP(listenHost); listenHost != "" && (ip == nil || !ip.IsUnspecified()) {
+		if !local && (listenHost == "localhost" || ip != nil && ip.IsLoopback()) {
+			return "", fmt.Errorf("the service listens on %s which the remote consul agent %s can not reach", listenHost, agentAddress)
+		}
+		return listenHost, nil
+	}
+	if !local {
+		return "", fmt.Errorf("the service listens on all interfaces and the consul agent %s is remote, "+
+			"set consul-service-address to an address the agent can reach", agentAddress)
+	}
+	return "127.0.0.1", nil
+}
+
+// isLocalAgent reports whether the consul agent address is a unix socket or a loopback address
+func isLocalAgent(address string) bool {
+	if strings.HasPrefix(address, "unix://") {
+		return true
+	}
+	if i := strings.Index(address, "://"); i >= 0 {
+		address = address[i+3:]
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,26 @@
+package main
+
+import "testing"
+
+func TestServiceCheckHost(t *testing.T) {
+	tests := []struct {
+		advertised, listen, agent string
+		want                      string
+		wantErr                   bool
+	}{
+		{"10.0.0.2", "0.0.0.0", "10.0.0.9:8500", "10.0.0.2", false},
+		{"", "0.0.0.0", "localhost:8500", "127.0.0.1", false},
+		{"", "::", "127.0.0.1:8500", "127.0.0.1", false},
+		{"", "", "unix:///var/run/consul.sock", "127.0.0.1", false},
+		{"", "10.0.0.2", "localhost:8500", "10.0.0.2", false},
+		{"", "10.0.0.2", "https://10.0.0.9:8501", "10.0.0.2", false},
+		{"", "0.0.0.0", "10.0.0.9:8500", "", true},
+		{"", "127.0.0.1", "consul.service:8500", "", true},
+	}
+	for _, tt := range tests {
+		got, err := serviceCheckHost(tt.advertised, tt.listen, tt.agent)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("serviceCheckHost(%q, %q, %q) = %q, %v, want %q, error %v", tt.advertised, tt.listen, tt.agent, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
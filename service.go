@@ -2,18 +2,253 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	snowflakepb "git.shiyou.kingsoft.com/infra/snowflake-service/proto"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"io"
+	"log"
+	"math"
+	"strconv"
+	"sync"
+	"time"
 )
 
 type Server struct {
+	maxBatchSize uint32
+	chunkSize    uint32
+	stopCh       chan struct{}
+	stopOnce     sync.Once
+}
+
+func newServer(maxBatchSize, chunkSize uint32) *Server {
+	return &Server{
+		maxBatchSize: maxBatchSize,
+		chunkSize:    chunkSize,
+		stopCh:       make(chan struct{}),
+	}
+}
+
+// livenessServiceName is the gRPC health service which is serving as long as the server runs, the consul
+// session the worker id lock is bound to follows its check.
+const livenessServiceName = "liveness"
+
+// newHealthServer creates the gRPC health service whose serving status follows the provider events,
+// it is serving while the worker id is held or self preserved. The liveness service is serving until
+// the server shuts down.
+func newHealthServer(p Provider) *health.Server {
+	h := health.NewServer()
+	h.SetServingStatus(livenessServiceName, healthpb.HealthCheckResponse_SERVING)
+	setStatus := func(status healthpb.HealthCheckResponse_ServingStatus) {
+		h.SetServingStatus("", status)
+		h.SetServingStatus(snowflakepb.Snowflake_ServiceDesc.ServiceName, status)
+	}
+	setStatus(healthpb.HealthCheckResponse_NOT_SERVING)
+	p.Subscribe(func(e ProviderEvent) {
+		switch e.Type {
+		case ProviderAcquired, ProviderSelfPreserving:
+			setStatus(healthpb.HealthCheckResponse_SERVING)
+		case ProviderLost, ProviderStopped:
+			setStatus(healthpb.HealthCheckResponse_NOT_SERVING)
+		}
+	})
+	return h
+}
+
+// Stop ends all the running StreamIds calls, it must be called before
+// grpc.Server.GracefulStop which would otherwise wait for the streams forever.
+func (s *Server) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stopCh)
+	})
 }
 
 func (s *Server) NextId(ctx context.Context, request *snowflakepb.NextIdRequest) (*snowflakepb.NextIdResponse, error) {
-	id := snowflake.NextId()
-	if id <= 0 {
-		return nil, status.Error(codes.Internal, "internal error")
+	id, err := snowflake.NextId()
+	if err != nil {
+		return nil, generatorError(err)
 	}
 	return &snowflakepb.NextIdResponse{Id: uint64(id)}, nil
 }
+
+func (s *Server) NextIds(ctx context.Context, request *snowflakepb.NextIdsRequest) (*snowflakepb.NextIdsResponse, error) {
+	count := request.GetCount()
+	if count == 0 {
+		return nil, status.Error(codes.InvalidArgument, "count must be greater than 0")
+	}
+	if count > s.maxBatchSize {
+		st := status.New(codes.InvalidArgument, fmt.Sprintf("count %d exceeds the max batch size %d", count, s.maxBatchSize))
+		if detailed, err := st.WithDetails(&errdetails.ErrorInfo{
+			Reason:   "BATCH_SIZE_EXCEEDED",
+			Domain:   errorDomain,
+			Metadata: map[string]string{"max_batch_size": strconv.FormatUint(uint64(s.maxBatchSize), 10)},
+		}); err == nil {
+			st = detailed
+		}
+		return nil, st.Err()
+	}
+	ids, err := snowflake.NextIds(int(count))
+	if err != nil {
+		return nil, generatorError(err)
+	}
+	response := &snowflakepb.NextIdsResponse{Ids: make([]uint64, len(ids))}
+	for i, id := range ids {
+		response.Ids[i] = uint64(id)
+	}
+	return response, nil
+}
+
+func (s *Server) StreamIds(stream snowflakepb.Snowflake_StreamIdsServer) error {
+	ctx := stream.Context()
+	requests := make(chan *snowflakepb.StreamIdsRequest)
+	recvErr := make(chan error, 1)
+	go func() {
+		for {
+			request, err := stream.Recv()
+			if err != nil {
+				recvErr <- err
+				return
+			}
+			select {
+			case requests <- request:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var credit uint64
+	chunkSize := s.chunkSize
+	closed := false
+	handle := func(request *snowflakepb.StreamIdsRequest) {
+		credit = addCredit(credit, request.GetCredit())
+		if size := request.GetChunkSize(); size > 0 {
+			chunkSize = size
+			if chunkSize > s.maxBatchSize {
+				chunkSize = s.maxBatchSize
+			}
+		}
+	}
+	for {
+		if credit == 0 {
+			if closed {
+				// the client half closed the stream and all the granted credits are consumed
+				return nil
+			}
+			// wait for more credits
+			select {
+			case <-s.stopCh:
+				return status.Error(codes.Unavailable, "server is shutting down")
+			case <-ctx.Done():
+				return status.FromContextError(ctx.Err()).Err()
+			case err := <-recvErr:
+				if err != io.EOF {
+					return err
+				}
+				closed = true
+			case request := <-requests:
+				handle(request)
+			}
+			continue
+		}
+		select {
+		case <-s.stopCh:
+			return status.Error(codes.Unavailable, "server is shutting down")
+		case err := <-recvErr:
+			if err != io.EOF {
+				return err
+			}
+			closed = true
+		case request := <-requests:
+			handle(request)
+		default:
+		}
+		n := uint64(chunkSize)
+		if n > credit {
+			n = credit
+		}
+		ids, err := snowflake.NextIds(int(n))
+		if err != nil {
+			return generatorError(err)
+		}
+		response := &snowflakepb.StreamIdsResponse{Ids: make([]uint64, len(ids))}
+		for i, id := range ids {
+			response.Ids[i] = uint64(id)
+		}
+		if err := stream.Send(response); err != nil {
+			return err
+		}
+		credit -= n
+	}
+}
+
+// addCredit adds the credits granted by the client, the sum saturates at MaxUint64 instead of wrapping around
+func addCredit(credit, more uint64) uint64 {
+	if credit > math.MaxUint64-more {
+		return math.MaxUint64
+	}
+	return credit + more
+}
+
+func (s *Server) ParseId(ctx context.Context, request *snowflakepb.ParseIdRequest) (*snowflakepb.ParseIdResponse, error) {
+	if request.GetId() > math.MaxInt64 {
+		return nil, status.Errorf(codes.InvalidArgument, "id %d is out of range", request.GetId())
+	}
+	info, err := snowflake.ParseId(int64(request.GetId()))
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return &snowflakepb.ParseIdResponse{
+		Timestamp:    info.Timestamp,
+		Time:         timestamppb.New(info.Time),
+		Rollback:     uint64(info.Rollback),
+		DatacenterId: uint64(info.DatacenterId),
+		WorkerId:     uint64(info.WorkerId),
+		Sequence:     uint64(info.Sequence),
+	}, nil
+}
+
+// errorDomain is the domain of the ErrorInfo details returned by the service
+const errorDomain = "seayoo.snowflake"
+
+// generatorErrors maps the errors of the Snowflake to the gRPC status codes and the ErrorInfo reasons,
+// retryAfter is the RetryInfo delay hint for the transient errors, zero means the client should not retry.
+var generatorErrors = []struct {
+	err        error
+	code       codes.Code
+	reason     string
+	retryAfter time.Duration
+}{
+	{ErrClockMovedBackwards, codes.Unavailable, "CLOCK_MOVED_BACKWARDS", 100 * time.Millisecond},
+	{ErrWorkerIdUnavailable, codes.Unavailable, "WORKER_ID_UNAVAILABLE", time.Second},
+	{ErrSequenceExhausted, codes.ResourceExhausted, "SEQUENCE_EXHAUSTED", time.Millisecond},
+	{ErrEpochExhausted, codes.FailedPrecondition, "EPOCH_EXHAUSTED", 0},
+}
+
+// generatorError converts an error of the Snowflake to a gRPC status error with an ErrorInfo detail,
+// and a RetryInfo detail if the error is transient, unknown errors are reported as Internal.
+func generatorError(err error) error {
+	for _, e := range generatorErrors {
+		if !errors.Is(err, e.err) {
+			continue
+		}
+		st := status.New(e.code, err.Error())
+		if detailed, err := st.WithDetails(&errdetails.ErrorInfo{Reason: e.reason, Domain: errorDomain}); err == nil {
+			st = detailed
+		}
+		if e.retryAfter > 0 {
+			if detailed, err := st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(e.retryAfter)}); err == nil {
+				st = detailed
+			}
+		}
+		return st.Err()
+	}
+	log.Printf("generate id error %v", err)
+	return status.Error(codes.Internal, "internal error")
+}
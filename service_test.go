@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	snowflakepb "git.shiyou.kingsoft.com/infra/snowflake-service/proto"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"math"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestAddCredit(t *testing.T) {
+	tests := []struct {
+		credit, more uint64
+		want         uint64
+	}{
+		{0, 10, 10},
+		{10, 0, 10},
+		{10, 20, 30},
+		{math.MaxUint64 - 1, 1, math.MaxUint64},
+		{math.MaxUint64 - 1, 2, math.MaxUint64},
+		{math.MaxUint64, math.MaxUint64, math.MaxUint64},
+	}
+	for _, tt := range tests {
+		if got := addCredit(tt.credit, tt.more); got != tt.want {
+			t.Errorf("addCredit(%d, %d) = %d, want %d", tt.credit, tt.more, got, tt.want)
+		}
+	}
+}
+
+// startTestServer serves the Snowflake service over an in-process connection and returns a client of it
+func startTestServer(t *testing.T, server *Server) snowflakepb.SnowflakeClient {
+	t.Helper()
+	sequence := SequencePolicy{Strategy: SequenceWaitSleep, Start: SequenceStartZero}
+	snowflake = newSnowflake(&SimpleProvider{workerId: 1}, testLayout, testEpoch, RollbackPolicy{}, sequence, systemClock{})
+	listener := bufconn.Listen(1 << 20)
+	s := grpc.NewServer()
+	snowflakepb.RegisterSnowflakeServer(s, server)
+	go s.Serve(listener)
+	t.Cleanup(s.Stop)
+	conn, err := grpc.Dial("bufconn", grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+		return listener.DialContext(ctx)
+	}))
+	if err != nil {
+		t.Fatalf("dial the test server error %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return snowflakepb.NewSnowflakeClient(conn)
+}
+
+func TestStreamIds(t *testing.T) {
+	server := newServer(100, 10)
+	client := startTestServer(t, server)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	stream, err := client.StreamIds(ctx)
+	if err != nil {
+		t.Fatalf("StreamIds error %v", err)
+	}
+	responses := make(chan *snowflakepb.StreamIdsResponse, 1024)
+	streamErr := make(chan error, 1)
+	go func() {
+		for {
+			response, err := stream.Recv()
+			if err != nil {
+				streamErr <- err
+				return
+			}
+			responses <- response
+		}
+	}()
+	expect := func(sizes ...int) {
+		t.Helper()
+		for _, size := range sizes {
+			select {
+			case response := <-responses:
+				if len(response.Ids) != size {
+					t.Fatalf("received %d ids, want %d", len(response.Ids), size)
+				}
+			case err := <-streamErr:
+				t.Fatalf("stream error %v", err)
+			case <-time.After(5 * time.Second):
+				t.Fatalf("no ids received, want %d", size)
+			}
+		}
+		select {
+		case response := <-responses:
+			t.Fatalf("received %d ids beyond the credits", len(response.Ids))
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	// the credits are sent in chunks of the default chunk size
+	if err := stream.Send(&snowflakepb.StreamIdsRequest{Credit: 25}); err != nil {
+		t.Fatalf("send credit error %v", err)
+	}
+	expect(10, 10, 5)
+	// the chunk size is limited by the max batch size
+	if err := stream.Send(&snowflakepb.StreamIdsRequest{Credit: 150, ChunkSize: 1000}); err != nil {
+		t.Fatalf("send credit error %v", err)
+	}
+	expect(100, 50)
+
+	// the credits saturate at MaxUint64, the stream keeps sending until the server stops
+	for i := 0; i < 2; i++ {
+		if err := stream.Send(&snowflakepb.StreamIdsRequest{Credit: math.MaxUint64, ChunkSize: 1}); err != nil {
+			t.Fatalf("send credit error %v", err)
+		}
+	}
+	for i := 0; i < 100; i++ {
+		select {
+		case <-responses:
+		case err := <-streamErr:
+			t.Fatalf("stream error %v", err)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("the stream stopped after %d ids with unlimited credits", i)
+		}
+	}
+
+	// Stop ends the stream with Unavailable
+	server.Stop()
+	for {
+		select {
+		case <-responses:
+			continue
+		case err := <-streamErr:
+			if status.Code(err) != codes.Unavailable {
+				t.Fatalf("the stream ended with %v after Stop, want Unavailable", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("the stream is not ended after Stop")
+		}
+		break
+	}
+}
+
+func TestGeneratorError(t *testing.T) {
+	tests := []struct {
+		err        error
+		code       codes.Code
+		reason     string
+		retryAfter time.Duration
+	}{
+		{ErrClockMovedBackwards, codes.Unavailable, "CLOCK_MOVED_BACKWARDS", 100 * time.Millisecond},
+		{fmt.Errorf("%w: provider is unavailable", ErrWorkerIdUnavailable), codes.Unavailable, "WORKER_ID_UNAVAILABLE", time.Second},
+		{fmt.Errorf("%w: clock has not moved for 100ms", ErrSequenceExhausted), codes.ResourceExhausted, "SEQUENCE_EXHAUSTED", time.Millisecond},
+		{fmt.Errorf("%w: timestamp exceeds the max timestamp", ErrEpochExhausted), codes.FailedPrecondition, "EPOCH_EXHAUSTED", 0},
+		{errors.New("unknown"), codes.Internal, "", 0},
+	}
+	for _, tt := range tests {
+		st := status.Convert(generatorError(tt.err))
+		var reason string
+		var retryAfter time.Duration
+		for _, detail := range st.Details() {
+			switch d := detail.(type) {
+			case *errdetails.ErrorInfo:
+				reason = d.Reason
+				if d.Domain != errorDomain {
+					t.Errorf("generatorError(%v) has ErrorInfo domain %q, want %q", tt.err, d.Domain, errorDomain)
+				}
+			case *errdetails.RetryInfo:
+				retryAfter = d.RetryDelay.AsDuration()
+			}
+		}
+		if st.Code() != tt.code || reason != tt.reason || retryAfter != tt.retryAfter {
+			t.Errorf("generatorError(%v) = %s, reason %q, retry after %s, want %s, reason %q, retry after %s",
+				tt.err, st.Code(), reason, retryAfter, tt.code, tt.reason, tt.retryAfter)
+		}
+	}
+}
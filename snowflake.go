@@ -1,8 +1,15 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"git.shiyou.kingsoft.com/infra/snowflake-service/idlayout"
 	"log"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -10,70 +17,422 @@ var snowflake *Snowflake
 
 var sonce sync.Once
 
-func initSnowflake(p Provider) *Snowflake {
+// ErrClockMovedBackwards 时钟回拨幅度超出了可以容忍的范围
+var ErrClockMovedBackwards = errors.New("clock moved backwards")
+
+// ErrWorkerIdUnavailable 暂时无法从Provider获取workerId或者datacenterId
+var ErrWorkerIdUnavailable = errors.New("worker id unavailable")
+
+// ErrEpochExhausted 时间戳超出了layout的最大值，需要更换epoch或者layout
+var ErrEpochExhausted = errors.New("epoch exhausted")
+
+// ErrSequenceExhausted 当前毫秒的序列号已用尽，并且等待maxSequenceWait后时钟仍然没有前进
+var ErrSequenceExhausted = errors.New("sequence exhausted")
+
+// ErrInvalidId 要解析的id不可能由当前配置的Snowflake生成
+var ErrInvalidId = errors.New("invalid id")
+
+func initSnowflake(p Provider, layout idlayout.Layout, epoch int64, policy RollbackPolicy, sequence SequencePolicy) *Snowflake {
 	sonce.Do(func() {
-		snowflake = &Snowflake{provider: p}
+		snowflake = newSnowflake(p, layout, epoch, policy, sequence, systemClock{})
 	})
 	return snowflake
 }
 
-type Snowflake struct {
-	sync.Mutex       // 锁
-	timestamp  int64 // 时间戳 ，毫秒
-	//workerId     int64 // 工作节点
-	datacenterId int64 // 数据中心机房id
-	sequence     int64 // 序列号
-	provider     Provider
+func newSnowflake(p Provider, layout idlayout.Layout, epoch int64, policy RollbackPolicy, sequence SequencePolicy, clock Clock) *Snowflake {
+	var maxLead int64
+	if sequence.Strategy == SequenceWaitBorrow {
+		maxLead = sequence.MaxLead.Milliseconds()
+	}
+	var salt uint64
+	if sequence.Start == SequenceStartRandom {
+		var b [8]byte
+		if _, err := rand.Read(b[:]); err != nil {
+			log.Fatalf("Generate the salt of the sequence start error: %v", err)
+		}
+		salt = binary.BigEndian.Uint64(b[:])
+	}
+	s := &Snowflake{
+		provider:           p,
+		layout:             layout,
+		epoch:              epoch,
+		policy:             policy,
+		maxLead:            maxLead,
+		randomStart:        sequence.Start == SequenceStartRandom,
+		sequenceSalt:       salt,
+		clock:              clock,
+		rollbackTimestamps: make([]int64, layout.MaxRollback()+1),
+	}
+	s.batchLead.Store(batchLead{})
+	return s
+}
+
+// RollbackPolicy 时钟回拨的处理策略
+type RollbackPolicy struct {
+	MaxWait   time.Duration // 回拨不超过MaxWait时阻塞等待时钟追上
+	MaxBorrow time.Duration // 回拨不超过MaxBorrow时借用回拨计数器继续生成id，超过则拒绝生成id
+}
+
+// SequencePolicy 每一毫秒序列号的起始值以及序列号用尽时的等待策略
+type SequencePolicy struct {
+	Strategy string        // SequenceWaitSleep或者SequenceWaitBorrow
+	MaxLead  time.Duration // SequenceWaitBorrow借用的时间戳最多领先系统时钟的时长
+	Start    string        // SequenceStartZero或者SequenceStartRandom
 }
 
 const (
-	epoch             = int64(1640966400000)                           // 设置起始时间(时间戳/毫秒)：2022-01-01 00:00:00，可使用至2091年
-	timestampBits     = uint(41)                                       // 时间戳占用位数
-	datacenterIdBits  = uint(2)                                        // 数据中心id所占位数
-	workerIdBits      = uint(8)                                        // 机器id所占位数
-	sequenceBits      = uint(12)                                       // 序列所占的位数
-	timestampMax      = int64(-1 ^ (-1 << timestampBits))              // 时间戳最大值
-	maxDatacenterId   = int64(-1 ^ (-1 << datacenterIdBits))           // 支持的最大数据中心id数量
-	maxWorkerId       = int64(-1 ^ (-1 << workerIdBits))               // 支持的最大机器id数量
-	sequenceMask      = int64(-1 ^ (-1 << sequenceBits))               // 支持的最大序列id数量
-	workerIdShift     = sequenceBits                                   // 机器id左移位数
-	datacenterIdShift = sequenceBits + workerIdBits                    // 数据中心id左移位数
-	timestampShift    = sequenceBits + workerIdBits + datacenterIdBits // 时间戳左移位数
+	SequenceStartZero   = "zero"   // 每一毫秒的序列号从0开始
+	SequenceStartRandom = "random" // 每一毫秒的序列号从时间戳和进程随机盐的哈希值开始，到最大值后回绕到0，直到回到起始值
 )
 
-func (s *Snowflake) NextId() int64 {
-	s.Lock()
-	defer s.Unlock()
-	now := time.Now().UnixMilli()
-	if s.timestamp == now {
-		// 当同一时间戳（精度：毫秒）下多次生成id会增加序列号
-		s.sequence = (s.sequence + 1) & sequenceMask
-		if s.sequence == 0 {
-			// 如果当前序列超出12bit长度，则需要等待下一毫秒
-			// 下一毫秒将使用sequence:0
-			for now <= s.timestamp {
-				now = time.Now().UnixMilli()
-			}
-		}
-	} else {
-		// 不同时间戳（精度：毫秒）下直接使用序列号：0
-		s.sequence = 0
+const (
+	SequenceWaitSleep  = "sleep"  // 睡眠等待下一毫秒
+	SequenceWaitBorrow = "borrow" // 借用下一毫秒的时间戳继续生成id，领先系统时钟超过MaxLead时睡眠等待
+)
+
+// sequenceSleepStep 序列号用尽时每次睡眠的时长，时钟只精确到毫秒，以较短的步长睡眠等待下一毫秒
+const sequenceSleepStep = 100 * time.Microsecond
+
+// maxSequenceWait 序列号用尽时最多睡眠等待的时长，超过后时钟仍然没有前进则返回ErrSequenceExhausted
+const maxSequenceWait = 100 * time.Millisecond
+
+// Snowflake 生成id时通过CAS更新打包的状态，不需要加锁，只有处理时钟回拨时才加锁
+type Snowflake struct {
+	sync.Mutex       // 锁，只在处理时钟回拨时使用
+	state      int64 // 打包的时间戳(相对epoch，毫秒)、回拨计数器和序列号，布局与id相同，workerId和datacenterId的位为0
+	//workerId     int64 // 工作节点
+	//datacenterId int64 // 数据中心机房id
+	rollbackTimestamps []int64 // 每个回拨计数器最后使用的时间戳(相对epoch)，需持有锁
+	notBefore          int64   // 重启前最后生成id的时间戳，时钟超过它之前拒绝生成id
+	provider           Provider
+	layout             idlayout.Layout
+	epoch              int64 // 起始时间，毫秒
+	policy             RollbackPolicy
+	maxLead            int64  // 借用的时间戳最多领先系统时钟的毫秒数，0表示不借用
+	randomStart        bool   // 每一毫秒的序列号是否从随机的起始值开始
+	sequenceSalt       uint64 // 计算序列号起始值的随机盐
+	clock              Clock
+	batchLead          atomic.Value // batchLead，NextIds跨毫秒预留的序列号领先系统时钟的范围
+}
+
+// batchLead NextIds在系统时钟为from(相对epoch)时预留了直到时间戳until的序列号，
+// 时钟不早于from时状态的时间戳不超过until的领先是批量预留造成的，等待时钟追上而不是当作时钟回拨
+type batchLead struct {
+	from  int64
+	until int64
+}
+
+// span 一段连续的序列号，word为第一个序列号对应的打包状态
+type span struct {
+	word  int64
+	count int64
+}
+
+// 默认起始时间(时间戳/毫秒)：2022-01-01 00:00:00 +08:00，默认布局下可使用至2091年
+const defaultEpoch = int64(1640966400000)
+
+// parseEpoch 解析起始时间，支持RFC3339格式或者毫秒时间戳
+func parseEpoch(value string) (int64, error) {
+	if millis, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return millis, nil
 	}
-	t := now - epoch
-	if t > timestampMax {
-		log.Printf("epoch must be between 0 and %d\n", timestampMax-1)
-		return 0
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return 0, fmt.Errorf("epoch %q is neither RFC3339 nor milliseconds", value)
+	}
+	return t.UnixMilli(), nil
+}
+
+// validateEpoch 检查起始时间不在未来，并且当前时间没有超出layout的时间戳最大值
+func validateEpoch(epoch int64, layout idlayout.Layout, now int64) error {
+	if epoch > now {
+		return fmt.Errorf("epoch %s is in the future", time.UnixMilli(epoch).Format(time.RFC3339))
+	}
+	if now-epoch > layout.MaxTimestamp() {
+		return fmt.Errorf("epoch %s with %d timestamp bits was exhausted at %s", time.UnixMilli(epoch).Format(time.RFC3339),
+			layout.TimestampBits, time.UnixMilli(epoch+layout.MaxTimestamp()).Format(time.RFC3339))
+	}
+	return nil
+}
+
+func (s *Snowflake) NextId() (int64, error) {
+	workerId, err := s.getWorkerId()
+	if err != nil {
+		log.Printf("get workerId error %v\n", err)
+		return 0, fmt.Errorf("%w: %v", ErrWorkerIdUnavailable, err)
 	}
-	s.timestamp = now
+	datacenterId, err := s.getDatacenterId()
+	if err != nil {
+		log.Printf("get datacenterId error %v\n", err)
+		return 0, fmt.Errorf("%w: %v", ErrWorkerIdUnavailable, err)
+	}
+	var buf [1]span
+	spans, err := s.reserve(1, buf[:0])
+	if err != nil {
+		return 0, err
+	}
+	return s.compose(spans[0].word, workerId, datacenterId), nil
+}
+
+// NextIds 通过一次CAS连续预留count个序列号，当前毫秒的序列号用尽后顺延到下一毫秒，直到满足count个为止。
+// 顺延的时间戳可能领先系统时钟，返回前等待时钟追上，使领先不超过maxLead
+func (s *Snowflake) NextIds(count int) ([]int64, error) {
 	workerId, err := s.getWorkerId()
 	if err != nil {
 		log.Printf("get workerId error %v\n", err)
+		return nil, fmt.Errorf("%w: %v", ErrWorkerIdUnavailable, err)
+	}
+	datacenterId, err := s.getDatacenterId()
+	if err != nil {
+		log.Printf("get datacenterId error %v\n", err)
+		return nil, fmt.Errorf("%w: %v", ErrWorkerIdUnavailable, err)
+	}
+	spans, err := s.reserve(int64(count), nil)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]int64, 0, count)
+	for _, sp := range spans {
+		for i := int64(0); i < sp.count; i++ {
+			ids = append(ids, s.compose(sp.word+i, workerId, datacenterId))
+		}
+	}
+	return ids, nil
+}
+
+// compose 把打包的时间戳、回拨计数器和序列号与workerId、datacenterId组合成id
+func (s *Snowflake) compose(word, workerId, datacenterId int64) int64 {
+	l := s.layout
+	return word | (datacenterId << l.DatacenterIdShift()) | (workerId << l.WorkerIdShift())
+}
+
+// reserve 通过一次CAS预留n个连续的序列号，按毫秒分段追加到spans中返回。
+// 跨毫秒预留时最后的时间戳可能领先系统时钟，领先超过maxLead时睡眠等待时钟追上后再返回
+func (s *Snowflake) reserve(n int64, spans []span) ([]span, error) {
+	l := s.layout
+	mask := l.SequenceMask()
+	var waitStart time.Time
+	base := len(spans)
+	for {
+		old := atomic.LoadInt64(&s.state)
+		timestamp := old >> l.TimestampShift()
+		rollback := old >> l.RollbackShift() & l.MaxRollback()
+		sequence := old & mask
+		now := s.clock.UnixMilli()
+		if now <= atomic.LoadInt64(&s.notBefore) {
+			clockRollbackCounter.WithLabelValues("reject").Inc()
+			return nil, ErrClockMovedBackwards
+		}
+		t := now - s.epoch
+		if timestamp-t > s.maxLead {
+			if lead := s.batchLead.Load().(batchLead); t >= lead.from && timestamp <= lead.until {
+				// 批量预留的时间戳领先系统时钟，等待时钟追上
+				s.clock.Sleep(time.Duration(timestamp-t-s.maxLead) * time.Millisecond)
+				continue
+			}
+			if err := s.handleRollback(old); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if t < timestamp {
+			// 借用的时间戳领先系统时钟不超过maxLead，继续使用借用的时间戳
+			t = timestamp
+		}
+		first := s.sequenceStart(t) // 不同时间戳（精度：毫秒）下从起始序列号开始
+		if t == timestamp {
+			if next := (sequence + 1) & mask; next == first {
+				// 当前毫秒的序列号已用尽，借用下一毫秒或者睡眠等待下一毫秒
+				if t+1-(now-s.epoch) <= s.maxLead {
+					sequenceExhaustedCounter.WithLabelValues(SequenceWaitBorrow).Inc()
+					t++
+					first = s.sequenceStart(t)
+				} else {
+					// 按实际经过的时间计算等待时长，每次睡眠可能远超sequenceSleepStep
+					if waitStart.IsZero() {
+						sequenceExhaustedCounter.WithLabelValues(SequenceWaitSleep).Inc()
+						waitStart = s.clock.Now()
+					} else if waited := s.clock.Now().Sub(waitStart); waited >= maxSequenceWait {
+						return nil, fmt.Errorf("%w: clock has not moved for %s", ErrSequenceExhausted, waited)
+					}
+					s.clock.Sleep(sequenceSleepStep)
+					continue
+				}
+			} else {
+				// 当同一时间戳（精度：毫秒）下多次生成id会增加序列号
+				first = next
+			}
+		}
+		// 每一毫秒内的序列号不能超过最大值，回绕后不能达到起始序列号，用尽后顺延到下一毫秒的起始序列号
+		spans = spans[:base]
+		remaining := n
+		var last int64
+		for {
+			limit := mask
+			if start := s.sequenceStart(t); first < start {
+				limit = start - 1
+			}
+			last = first + remaining - 1
+			if last > limit {
+				last = limit
+			}
+			spans = append(spans, span{word: t<<l.TimestampShift() | rollback<<l.RollbackShift() | first, count: last - first + 1})
+			if remaining -= last - first + 1; remaining == 0 {
+				break
+			}
+			if first = (last + 1) & mask; first == s.sequenceStart(t) {
+				t++
+				first = s.sequenceStart(t)
+			}
+		}
+		if t > l.MaxTimestamp() {
+			return nil, fmt.Errorf("%w: timestamp %d exceeds the max timestamp %d", ErrEpochExhausted, t, l.MaxTimestamp())
+		}
+		if lead := t - (now - s.epoch); lead > s.maxLead {
+			s.extendBatchLead(now-s.epoch, t)
+		}
+		if atomic.CompareAndSwapInt64(&s.state, old, t<<l.TimestampShift()|rollback<<l.RollbackShift()|last) {
+			if lead := t - (now - s.epoch) - s.maxLead; lead > 0 {
+				s.clock.Sleep(time.Duration(lead) * time.Millisecond)
+			}
+			return spans, nil
+		}
+	}
+}
+
+// extendBatchLead 在CAS之前记录批量预留的领先范围，只会向后延长，
+// CAS失败时记录的范围没有对应的预留，只会让其他调用多等待而不会重复
+func (s *Snowflake) extendBatchLead(from, until int64) {
+	for {
+		old := s.batchLead.Load().(batchLead)
+		if old.until >= until || s.batchLead.CompareAndSwap(old, batchLead{from: from, until: until}) {
+			return
+		}
+	}
+}
+
+// sequenceStart 时间戳t的起始序列号，随机起始时为t和随机盐的哈希值(splitmix64)，不需要额外保存状态
+func (s *Snowflake) sequenceStart(t int64) int64 {
+	if !s.randomStart {
 		return 0
 	}
-	r := (t)<<timestampShift | (s.datacenterId << datacenterIdShift) | (workerId << workerIdShift) | (s.sequence)
-	return r
+	z := uint64(t) ^ s.sequenceSalt
+	z += 0x9e3779b97f4a7c15
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	z ^= z >> 31
+	return int64(z) & s.layout.SequenceMask()
+}
+
+// handleRollback 处理时钟回拨，old为检测到回拨时的状态，返回nil时调用方重新尝试生成id
+//   - 回拨不超过MaxWait：等待时钟追上上一次生成id的时间戳
+//   - 回拨不超过MaxBorrow：切换到一个在now之后没有使用过的回拨计数器，切换后生成的id不会和之前的重复，但不再保证单调递增
+//   - 其他情况返回ErrClockMovedBackwards
+func (s *Snowflake) handleRollback(old int64) error {
+	s.Lock()
+	defer s.Unlock()
+	l := s.layout
+	timestamp := old >> l.TimestampShift()
+	rollback := old >> l.RollbackShift() & l.MaxRollback()
+	// 等待锁期间其他goroutine可能已经处理了回拨或者时钟已经追上
+	t := s.clock.UnixMilli() - s.epoch
+	if atomic.LoadInt64(&s.state) != old || t >= timestamp {
+		return nil
+	}
+	offset := timestamp - t
+	if offset <= s.policy.MaxWait.Milliseconds() {
+		clockRollbackCounter.WithLabelValues("wait").Inc()
+		s.clock.Sleep(time.Duration(offset) * time.Millisecond)
+		if t = s.clock.UnixMilli() - s.epoch; t >= timestamp {
+			return nil
+		}
+		offset = timestamp - t
+	}
+	if offset <= s.policy.MaxBorrow.Milliseconds() {
+		maxRollback := l.MaxRollback()
+		for i := int64(1); i <= maxRollback; i++ {
+			next := (rollback + i) & maxRollback
+			if last := s.rollbackTimestamps[next]; last < t {
+				// 切换后的状态标记该计数器最后使用的时间戳的序列号已用尽（起始序列号的前一个），
+				// 随机起始时也不能是SequenceMask，否则时钟再次回拨到该时间戳时会复用序列号
+				used := (s.sequenceStart(last) - 1) & l.SequenceMask()
+				if !atomic.CompareAndSwapInt64(&s.state, old, last<<l.TimestampShift()|next<<l.RollbackShift()|used) {
+					return nil
+				}
+				log.Printf("Warnning: clock moved backwards %dms, switch rollback counter from %d to %d", offset, rollback, next)
+				clockRollbackCounter.WithLabelValues("borrow").Inc()
+				s.rollbackTimestamps[rollback] = timestamp
+				return nil
+			}
+		}
+	}
+	log.Printf("Fatal: clock moved backwards %dms, refuse to generate id until %d", offset, s.epoch+timestamp)
+	clockRollbackCounter.WithLabelValues("reject").Inc()
+	return ErrClockMovedBackwards
+}
+
+// ParseId 按照当前Snowflake的layout和epoch解析id，时间戳领先当前时间超过可借用时长的id返回ErrInvalidId
+func (s *Snowflake) ParseId(id int64) (idlayout.IdInfo, error) {
+	if id < 0 {
+		return idlayout.IdInfo{}, fmt.Errorf("%w: id %d is negative", ErrInvalidId, id)
+	}
+	info := idlayout.ParseId(id, s.layout, s.epoch)
+	if now := s.clock.UnixMilli(); s.epoch+info.Timestamp > now+s.maxLead {
+		return idlayout.IdInfo{}, fmt.Errorf("%w: id %d is generated at %s which is in the future", ErrInvalidId, id, info.Time.Format(time.RFC3339Nano))
+	}
+	return info, nil
+}
+
+// RestoreTimestamp 恢复重启前最后生成id的时间戳，时钟超过该时间戳之前拒绝生成id
+func (s *Snowflake) RestoreTimestamp(timestamp int64) {
+	atomic.StoreInt64(&s.notBefore, timestamp)
+	if now := s.clock.UnixMilli(); now <= timestamp {
+		log.Printf("Warnning: the clock is %dms behind the timestamp persisted before restart, refuse to generate id until %d", timestamp-now, timestamp)
+	}
+}
+
+// LastTimestamp 最后生成id的时间戳，包括借用过的回拨计数器和重启前的
+func (s *Snowflake) LastTimestamp() int64 {
+	s.Lock()
+	defer s.Unlock()
+	last := atomic.LoadInt64(&s.state) >> s.layout.TimestampShift()
+	for _, timestamp := range s.rollbackTimestamps {
+		if timestamp > last {
+			last = timestamp
+		}
+	}
+	if notBefore := atomic.LoadInt64(&s.notBefore); notBefore > s.epoch+last {
+		return notBefore
+	}
+	return s.epoch + last
+}
+
+// Lead 当前借用的时间戳领先系统时钟的时长，没有借用时为0
+func (s *Snowflake) Lead() time.Duration {
+	timestamp := atomic.LoadInt64(&s.state) >> s.layout.TimestampShift()
+	if lead := s.epoch + timestamp - s.clock.UnixMilli(); lead > 0 {
+		return time.Duration(lead) * time.Millisecond
+	}
+	return 0
+}
+
+// MaxLead 借用的时间戳最多领先系统时钟的时长，不借用时为0
+func (s *Snowflake) MaxLead() time.Duration {
+	return time.Duration(s.maxLead) * time.Millisecond
+}
+
+// RemainingLifetime 距离时间戳用尽还剩余的时间
+func (s *Snowflake) RemainingLifetime() time.Duration {
+	return time.Duration(s.epoch+s.layout.MaxTimestamp()-s.clock.UnixMilli()) * time.Millisecond
 }
 
 func (s *Snowflake) getWorkerId() (int64, error) {
 	return s.provider.GetWorkerId()
 }
+
+func (s *Snowflake) getDatacenterId() (int64, error) {
+	if p, ok := s.provider.(DatacenterProvider); ok {
+		return p.GetDatacenterId()
+	}
+	return 0, nil
+}
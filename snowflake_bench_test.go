@@ -0,0 +1,124 @@
+package main
+
+import (
+	"git.shiyou.kingsoft.com/infra/snowflake-service/idlayout"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Run the benchmarks at various GOMAXPROCS with:
+//
+//	go test -run '^$' -bench . -cpu 1,2,4,8,16
+
+// benchLayout has enough sequence bits that the benchmarks measure the contention of the generators
+// rather than waiting for the next millisecond once the sequences are exhausted.
+var benchLayout = idlayout.Layout{
+	TimestampBits: 41,
+	SequenceBits:  22,
+}
+
+// mutexSnowflake is the generator before the CAS redesign, every id takes the mutex and reads the clock
+// under it, it is kept as the baseline of the benchmarks.
+type mutexSnowflake struct {
+	sync.Mutex
+	provider  Provider
+	layout    idlayout.Layout
+	epoch     int64
+	timestamp int64
+	sequence  int64
+	clock     Clock
+}
+
+func (s *mutexSnowflake) NextId() (int64, error) {
+	s.Lock()
+	defer s.Unlock()
+	workerId, err := s.provider.GetWorkerId()
+	if err != nil {
+		return 0, err
+	}
+	return s.nextId(workerId)
+}
+
+func (s *mutexSnowflake) NextIds(count int) ([]int64, error) {
+	s.Lock()
+	defer s.Unlock()
+	workerId, err := s.provider.GetWorkerId()
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]int64, count)
+	for i := range ids {
+		id, err := s.nextId(workerId)
+		if err != nil {
+			return nil, err
+		}
+		ids[i] = id
+	}
+	return ids, nil
+}
+
+func (s *mutexSnowflake) nextId(workerId int64) (int64, error) {
+	now := s.clock.UnixMilli()
+	if now < s.timestamp {
+		return 0, ErrClockMovedBackwards
+	}
+	if s.timestamp == now {
+		s.sequence = (s.sequence + 1) & s.layout.SequenceMask()
+		if s.sequence == 0 {
+			for now <= s.timestamp {
+				now = s.clock.UnixMilli()
+			}
+		}
+	} else {
+		s.sequence = 0
+	}
+	s.timestamp = now
+	l := s.layout
+	return (now-s.epoch)<<l.TimestampShift() | workerId<<l.WorkerIdShift() | s.sequence, nil
+}
+
+type generator interface {
+	NextId() (int64, error)
+	NextIds(count int) ([]int64, error)
+}
+
+func benchGenerators() map[string]generator {
+	epoch := time.Now().Add(-time.Hour).UnixMilli()
+	sequence := SequencePolicy{Strategy: SequenceWaitSleep, Start: SequenceStartZero}
+	return map[string]generator{
+		"mutex": &mutexSnowflake{provider: &SimpleProvider{}, layout: benchLayout, epoch: epoch, clock: systemClock{}},
+		"cas":   newSnowflake(&SimpleProvider{}, benchLayout, epoch, RollbackPolicy{}, sequence, systemClock{}),
+	}
+}
+
+func BenchmarkNextId(b *testing.B) {
+	for _, name := range []string{"mutex", "cas"} {
+		b.Run(name, func(b *testing.B) {
+			g := benchGenerators()[name]
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if _, err := g.NextId(); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkNextIds(b *testing.B) {
+	const count = 100
+	for _, name := range []string{"mutex", "cas"} {
+		b.Run(name, func(b *testing.B) {
+			g := benchGenerators()[name]
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if _, err := g.NextIds(count); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"errors"
+	"git.shiyou.kingsoft.com/infra/snowflake-service/idlayout"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testEpoch = int64(1600000000000)
+
+// testLayout has a rollback counter of one bit, so the clock rollback can be borrowed once
+var testLayout = idlayout.Layout{TimestampBits: 41, RollbackBits: 1, DatacenterIdBits: 1, WorkerIdBits: 8, SequenceBits: 12}
+
+// fakeClock is a Clock which only moves when the test sets it or the Snowflake sleeps
+type fakeClock struct {
+	nanos int64
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{nanos: (testEpoch + int64(time.Hour/time.Millisecond)) * int64(time.Millisecond)}
+}
+
+func (c *fakeClock) UnixMilli() int64 {
+	return atomic.LoadInt64(&c.nanos) / int64(time.Millisecond)
+}
+
+func (c *fakeClock) Now() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&c.nanos))
+}
+
+func (c *fakeClock) Sleep(d time.Duration) {
+	c.Add(d)
+}
+
+func (c *fakeClock) Add(d time.Duration) {
+	atomic.AddInt64(&c.nanos, int64(d))
+}
+
+func newTestSnowflake(clock Clock, policy RollbackPolicy) *Snowflake {
+	sequence := SequencePolicy{Strategy: SequenceWaitSleep, Start: SequenceStartZero}
+	return newSnowflake(&SimpleProvider{workerId: 1}, testLayout, testEpoch, policy, sequence, clock)
+}
+
+func mustNextId(t *testing.T, s *Snowflake) int64 {
+	t.Helper()
+	id, err := s.NextId()
+	if err != nil {
+		t.Fatalf("NextId error %v", err)
+	}
+	return id
+}
+
+func TestRollbackWait(t *testing.T) {
+	clock := newFakeClock()
+	s := newTestSnowflake(clock, RollbackPolicy{MaxWait: 10 * time.Millisecond, MaxBorrow: time.Second})
+	before := mustNextId(t, s)
+	last := clock.UnixMilli()
+
+	clock.Add(-5 * time.Millisecond)
+	after := mustNextId(t, s)
+	if after <= before {
+		t.Errorf("id %d after waiting must be greater than %d", after, before)
+	}
+	if now := clock.UnixMilli(); now < last {
+		t.Errorf("clock %d must catch up with %d after waiting", now, last)
+	}
+	if info := idlayout.ParseId(after, testLayout, testEpoch); info.Rollback != 0 {
+		t.Errorf("rollback counter must stay 0 after waiting, got %d", info.Rollback)
+	}
+}
+
+func TestRollbackBorrow(t *testing.T) {
+	clock := newFakeClock()
+	s := newTestSnowflake(clock, RollbackPolicy{MaxBorrow: time.Second})
+	seen := make(map[int64]bool)
+	for i := 0; i < 10; i++ {
+		seen[mustNextId(t, s)] = true
+		clock.Add(time.Millisecond)
+	}
+
+	clock.Add(-50 * time.Millisecond)
+	for i := 0; i < 10; i++ {
+		id := mustNextId(t, s)
+		if seen[id] {
+			t.Fatalf("id %d generated after borrowing a rollback counter is duplicated", id)
+		}
+		if info := idlayout.ParseId(id, testLayout, testEpoch); info.Rollback != 1 {
+			t.Fatalf("id %d must use rollback counter 1, got %d", id, info.Rollback)
+		}
+		seen[id] = true
+		clock.Add(time.Millisecond)
+	}
+
+	// counter 0 has been used at later timestamps, it can not be borrowed by a second rollback
+	clock.Add(-50 * time.Millisecond)
+	if _, err := s.NextId(); !errors.Is(err, ErrClockMovedBackwards) {
+		t.Errorf("rollback without a free counter must return ErrClockMovedBackwards, got %v", err)
+	}
+}
+
+func TestRollbackReject(t *testing.T) {
+	clock := newFakeClock()
+	s := newTestSnowflake(clock, RollbackPolicy{MaxWait: 10 * time.Millisecond, MaxBorrow: time.Second})
+	before := mustNextId(t, s)
+
+	clock.Add(-2 * time.Second)
+	if _, err := s.NextId(); !errors.Is(err, ErrClockMovedBackwards) {
+		t.Fatalf("rollback over MaxBorrow must return ErrClockMovedBackwards, got %v", err)
+	}
+
+	clock.Add(2*time.Second + time.Millisecond)
+	if after := mustNextId(t, s); after <= before {
+		t.Errorf("id %d after the clock caught up must be greater than %d", after, before)
+	}
+}
+
+func TestRestoreTimestamp(t *testing.T) {
+	clock := newFakeClock()
+	s := newTestSnowflake(clock, RollbackPolicy{})
+	s.RestoreTimestamp(clock.UnixMilli() + 5)
+	if _, err := s.NextId(); !errors.Is(err, ErrClockMovedBackwards) {
+		t.Fatalf("NextId before the restored timestamp must return ErrClockMovedBackwards, got %v", err)
+	}
+	clock.Add(6 * time.Millisecond)
+	mustNextId(t, s)
+}
+
+// stuckClock is a fakeClock whose milliseconds never move, and every Sleep takes 1ms whatever is requested
+type stuckClock struct {
+	*fakeClock
+	milli  int64
+	sleeps int
+}
+
+func (c *stuckClock) UnixMilli() int64 {
+	return c.milli
+}
+
+func (c *stuckClock) Sleep(time.Duration) {
+	c.sleeps++
+	c.Add(time.Millisecond)
+}
+
+func TestSequenceExhausted(t *testing.T) {
+	fake := newFakeClock()
+	clock := &stuckClock{fakeClock: fake, milli: fake.UnixMilli()}
+	s := newTestSnowflake(clock, RollbackPolicy{})
+	slept := testutil.ToFloat64(sequenceExhaustedCounter.WithLabelValues(SequenceWaitSleep))
+	for i := int64(0); i <= testLayout.SequenceMask(); i++ {
+		mustNextId(t, s)
+	}
+	if _, err := s.NextId(); !errors.Is(err, ErrSequenceExhausted) {
+		t.Fatalf("NextId after the clock stuck must return ErrSequenceExhausted, got %v", err)
+	}
+	// one wait is counted once however many times it sleeps
+	if n := testutil.ToFloat64(sequenceExhaustedCounter.WithLabelValues(SequenceWaitSleep)) - slept; n != 1 {
+		t.Errorf("the sequence exhausted counter increased by %v, want 1", n)
+	}
+	// the wait is bounded by the elapsed time rather than the number of the requested sleep steps
+	if want := int(maxSequenceWait / time.Millisecond); clock.sleeps != want {
+		t.Errorf("NextId must give up after sleeping %d times, slept %d times", want, clock.sleeps)
+	}
+}
+
+func TestSequenceBorrow(t *testing.T) {
+	clock := newFakeClock()
+	sequence := SequencePolicy{Strategy: SequenceWaitBorrow, MaxLead: 2 * time.Millisecond, Start: SequenceStartZero}
+	s := newSnowflake(&SimpleProvider{workerId: 1}, testLayout, testEpoch, RollbackPolicy{}, sequence, clock)
+	borrowed := testutil.ToFloat64(sequenceExhaustedCounter.WithLabelValues(SequenceWaitBorrow))
+	slept := testutil.ToFloat64(sequenceExhaustedCounter.WithLabelValues(SequenceWaitSleep))
+	now := clock.UnixMilli()
+	perMilli := testLayout.SequenceMask() + 1
+
+	// the sequences of the next 2 milliseconds are borrowed without the clock moving
+	for i := int64(0); i < 3*perMilli; i++ {
+		info := idlayout.ParseId(mustNextId(t, s), testLayout, testEpoch)
+		if want := now - testEpoch + i/perMilli; info.Timestamp != want || info.Sequence != i%perMilli {
+			t.Fatalf("id %d has timestamp %d and sequence %d, want %d and %d", i, info.Timestamp, info.Sequence, want, i%perMilli)
+		}
+	}
+	if clock.UnixMilli() != now {
+		t.Errorf("the clock moved %dms while borrowing", clock.UnixMilli()-now)
+	}
+	if lead := s.Lead(); lead != 2*time.Millisecond {
+		t.Errorf("Lead() = %s after borrowing 2 milliseconds, want 2ms", lead)
+	}
+
+	// the lead is capped at MaxLead, the next id sleeps until the clock moves and then borrows again
+	info := idlayout.ParseId(mustNextId(t, s), testLayout, testEpoch)
+	if clock.UnixMilli() != now+1 {
+		t.Errorf("the clock moved %dms while sleeping, want 1ms", clock.UnixMilli()-now)
+	}
+	if want := now - testEpoch + 3; info.Timestamp != want {
+		t.Errorf("id after sleeping has timestamp %d, want %d", info.Timestamp, want)
+	}
+	if lead := s.Lead(); lead != 2*time.Millisecond {
+		t.Errorf("Lead() = %s after sleeping, want 2ms", lead)
+	}
+	if n := testutil.ToFloat64(sequenceExhaustedCounter.WithLabelValues(SequenceWaitBorrow)) - borrowed; n != 3 {
+		t.Errorf("borrowed %v times, want 3", n)
+	}
+	if n := testutil.ToFloat64(sequenceExhaustedCounter.WithLabelValues(SequenceWaitSleep)) - slept; n != 1 {
+		t.Errorf("slept %v times, want 1", n)
+	}
+
+	clock.Add(10 * time.Millisecond)
+	if lead := s.Lead(); lead != 0 {
+		t.Errorf("Lead() = %s after the clock caught up, want 0", lead)
+	}
+}
+
+// scriptedClock is a fakeClock whose next readings of the milliseconds are scripted
+type scriptedClock struct {
+	*fakeClock
+	readings []int64
+}
+
+func (c *scriptedClock) UnixMilli() int64 {
+	if len(c.readings) > 0 {
+		milli := c.readings[0]
+		c.readings = c.readings[1:]
+		return milli
+	}
+	return c.fakeClock.UnixMilli()
+}
+
+func TestRollbackBorrowRandomStart(t *testing.T) {
+	clock := &scriptedClock{fakeClock: newFakeClock()}
+	sequence := SequencePolicy{Strategy: SequenceWaitSleep, Start: SequenceStartRandom}
+	s := newSnowflake(&SimpleProvider{workerId: 1}, testLayout, testEpoch, RollbackPolicy{MaxBorrow: time.Second}, sequence, clock)
+	seen := make(map[int64]bool)
+	generate := func(n int64) {
+		t.Helper()
+		for i := int64(0); i < n; i++ {
+			id := mustNextId(t, s)
+			if seen[id] {
+				info := idlayout.ParseId(id, testLayout, testEpoch)
+				t.Fatalf("id %d is duplicated, timestamp %d, rollback %d, sequence %d", id, info.Timestamp, info.Rollback, info.Sequence)
+			}
+			seen[id] = true
+		}
+	}
+
+	// counter 0 uses every sequence of the first millisecond, wrapping around from the random start
+	first := clock.UnixMilli()
+	generate(testLayout.SequenceMask() + 1)
+	// switch to counter 1
+	clock.Add(-10 * time.Millisecond)
+	generate(10)
+	clock.Add(30 * time.Millisecond)
+	generate(10)
+	// switch back to counter 0 which was last used at the first millisecond, the clock steps back to it
+	// right after the switch
+	clock.Add(-15 * time.Millisecond)
+	clock.readings = []int64{first + 5, first + 5, first}
+	generate(testLayout.SequenceMask() + 1)
+	if info := idlayout.ParseId(mustNextId(t, s), testLayout, testEpoch); info.Rollback != 0 {
+		t.Errorf("ids after switching back must use rollback counter 0, got %d", info.Rollback)
+	}
+}
+
+func TestNextIdsContiguous(t *testing.T) {
+	clock := newFakeClock()
+	sequence := SequencePolicy{Strategy: SequenceWaitSleep, Start: SequenceStartRandom}
+	s := newSnowflake(&SimpleProvider{workerId: 1}, testLayout, testEpoch, RollbackPolicy{}, sequence, clock)
+	mask := testLayout.SequenceMask()
+	count := int(mask+1)*5/2 + 7
+
+	var wg sync.WaitGroup
+	batches := make([][]int64, 8)
+	errs := make([]error, len(batches))
+	for i := range batches {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				ids, err := s.NextIds(count)
+				if err != nil {
+					errs[i] = err
+					return
+				}
+				batches[i] = append(batches[i], ids...)
+				if _, err := s.NextId(); err != nil {
+					errs[i] = err
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	seen := make(map[int64]bool)
+	for i, ids := range batches {
+		if errs[i] != nil {
+			t.Fatalf("NextIds error %v", errs[i])
+		}
+		for j, id := range ids {
+			if seen[id] {
+				t.Fatalf("id %d is duplicated", id)
+			}
+			seen[id] = true
+			if j%count == 0 {
+				continue
+			}
+			// the sequences of a batch follow each other, the next millisecond starts after the sequences wrap
+			prev, info := idlayout.ParseId(ids[j-1], testLayout, testEpoch), idlayout.ParseId(id, testLayout, testEpoch)
+			next := (prev.Sequence + 1) & mask
+			if info.Timestamp == prev.Timestamp && info.Sequence == next && next != s.sequenceStart(prev.Timestamp) {
+				continue
+			}
+			if info.Timestamp == prev.Timestamp+1 && next == s.sequenceStart(prev.Timestamp) && info.Sequence == s.sequenceStart(info.Timestamp) {
+				continue
+			}
+			t.Fatalf("id %d of a batch (timestamp %d, sequence %d) does not follow timestamp %d, sequence %d",
+				j%count, info.Timestamp, info.Sequence, prev.Timestamp, prev.Sequence)
+		}
+	}
+}
+
+func TestNextIdsLead(t *testing.T) {
+	clock := newFakeClock()
+	s := newTestSnowflake(clock, RollbackPolicy{})
+	now := clock.UnixMilli()
+	perMilli := testLayout.SequenceMask() + 1
+
+	// the batch runs 2 milliseconds ahead of the clock and waits them out before returning
+	ids, err := s.NextIds(int(3 * perMilli))
+	if err != nil {
+		t.Fatalf("NextIds error %v", err)
+	}
+	if info := idlayout.ParseId(ids[len(ids)-1], testLayout, testEpoch); info.Timestamp != now-testEpoch+2 {
+		t.Errorf("the last id of the batch has timestamp %d, want %d", info.Timestamp, now-testEpoch+2)
+	}
+	if clock.UnixMilli() != now+2 {
+		t.Errorf("NextIds waited %dms, want 2ms", clock.UnixMilli()-now)
+	}
+
+	// a caller seeing the lead of the batch waits for the clock instead of treating it as a clock rollback
+	clock.Add(-2 * time.Millisecond)
+	rejected := testutil.ToFloat64(clockRollbackCounter.WithLabelValues("reject"))
+	if info := idlayout.ParseId(mustNextId(t, s), testLayout, testEpoch); info.Timestamp != now-testEpoch+3 {
+		t.Errorf("the id after the batch has timestamp %d, want %d", info.Timestamp, now-testEpoch+3)
+	}
+	if n := testutil.ToFloat64(clockRollbackCounter.WithLabelValues("reject")) - rejected; n != 0 {
+		t.Errorf("the lead of the batch was rejected %v times as a clock rollback", n)
+	}
+
+	// the clock moving back before the batch was reserved is still a clock rollback
+	clock.Add(-10 * time.Millisecond)
+	if _, err := s.NextId(); !errors.Is(err, ErrClockMovedBackwards) {
+		t.Errorf("rollback before the batch must return ErrClockMovedBackwards, got %v", err)
+	}
+}
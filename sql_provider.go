@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	"log"
+	_ "modernc.org/sqlite"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var sqlProvider *SQLProvider
+
+func getSQLProvider(driver string, dsn string, table string, ttl time.Duration, hintWorkerId int64, maxWorkerId int64, enableSelfPreservation bool, safeSelfPreservation bool) *SQLProvider {
+	once.Do(func() {
+		db, err := sql.Open(driver, dsn)
+		if err != nil {
+			log.Fatalf("Open %s database error: %v", driver, err)
+		}
+		if err := createLeaseTable(db, table); err != nil {
+			log.Fatalf("Create lease table %s error: %v", table, err)
+		}
+		sqlProvider = newSQLProvider(db, driver, table, ttl, hintWorkerId, maxWorkerId, enableSelfPreservation, safeSelfPreservation)
+	})
+	return sqlProvider
+}
+
+// createLeaseTable creates the table of the worker id leases if not exists, the statement works
+// with mysql, postgres and sqlite.
+func createLeaseTable(db *sql.DB, table string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := db.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS "+table+` (
+	worker_id BIGINT NOT NULL PRIMARY KEY,
+	owner VARCHAR(255) NOT NULL,
+	expires_at BIGINT NOT NULL,
+	epoch BIGINT NOT NULL
+)`)
+	return err
+}
+
+// newSQLProvider creates a SQLProvider with the given database whose lease table exists and
+// starts acquiring a worker id, the database can be an in-process sqlite. Every connection to an
+// in-memory sqlite opens a separate database, so db.SetMaxOpenConns(1) must be set for it.
+func newSQLProvider(db *sql.DB, driver string, table string, ttl time.Duration, hintWorkerId int64, maxWorkerId int64, enableSelfPreservation bool, safeSelfPreservation bool) *SQLProvider {
+	hostname, _ := os.Hostname()
+	p := &SQLProvider{
+		driver: driver,
+		table:  table,
+		ttl:    ttl,
+		owner:  fmt.Sprintf("%s-%d-%d", hostname, os.Getpid(), time.Now().UnixNano()),
+		db:     db,
+	}
+	p.init("sql", hintWorkerId, maxWorkerId, enableSelfPreservation, safeSelfPreservation)
+	p.leaseEpoch.Store(int64(0))
+	go p.acquire(p.tryLock, func() {})
+	return p
+}
+
+// SQLProvider acquires a worker id by claiming a free or expired row of the lease table, every
+// claim increases the epoch of the row so a stale owner can not renew the lease any more. The
+// lease is renewed every ttl/3 and the worker id is treated as lost once the row is claimed by
+// others or could not be renewed before it expires.
+type SQLProvider struct {
+	lockHolder
+	leaseEpoch atomic.Value
+	driver     string
+	table      string
+	ttl        time.Duration
+	owner      string
+	db         *sql.DB
+}
+
+// tryLock claims the lease of the worker id and keeps renewing it, the returned channel is closed once
+// the lease is lost. The lease is claimed by others or expired already then, so there is no grace period
+// for the safe self preservation.
+func (p *SQLProvider) tryLock(workerId int64) (<-chan struct{}, time.Duration, error) {
+	epoch, validUntil, err := p.lock(workerId)
+	if err != nil {
+		return nil, 0, err
+	}
+	p.leaseEpoch.Store(epoch)
+	log.Printf("claim the lease of worker id %d, epoch: %d", workerId, epoch)
+	p.validUntil.Store(validUntil)
+	lostCh := make(chan struct{})
+	go p.renew(workerId, epoch, validUntil, lostCh)
+	return lostCh, 0, nil
+}
+
+// lock claims the row of the worker id in a transaction, the row is inserted if it does not exist,
+// returns the new epoch of the row and until when the claim is valid.
+func (p *SQLProvider) lock(workerId int64) (int64, time.Time, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	now := time.Now()
+	validUntil := now.Add(p.ttl)
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	defer tx.Rollback()
+	result, err := tx.ExecContext(ctx, p.query("UPDATE "+p.table+" SET owner = ?, expires_at = ?, epoch = epoch + 1 WHERE worker_id = ? AND expires_at < ?"),
+		p.owner, validUntil.UnixMilli(), workerId, now.UnixMilli())
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return 0, time.Time{}, err
+	} else if n == 0 {
+		var owner string
+		err := tx.QueryRowContext(ctx, p.query("SELECT owner FROM "+p.table+" WHERE worker_id = ?"), workerId).Scan(&owner)
+		if err == nil {
+			return 0, time.Time{}, fmt.Errorf("worker id %d is held by %s", workerId, owner)
+		}
+		if err != sql.ErrNoRows {
+			return 0, time.Time{}, err
+		}
+		// the unique primary key makes the insert fail if others claim the worker id at the same time
+		if _, err := tx.ExecContext(ctx, p.query("INSERT INTO "+p.table+" (worker_id, owner, expires_at, epoch) VALUES (?, ?, ?, 0)"),
+			workerId, p.owner, validUntil.UnixMilli()); err != nil {
+			return 0, time.Time{}, err
+		}
+	}
+	var epoch int64
+	if err := tx.QueryRowContext(ctx, p.query("SELECT epoch FROM "+p.table+" WHERE worker_id = ?"), workerId).Scan(&epoch); err != nil {
+		return 0, time.Time{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, time.Time{}, err
+	}
+	return epoch, validUntil, nil
+}
+
+// renew extends the lease of the worker id periodically, lostCh is closed once the lease is lost
+func (p *SQLProvider) renew(workerId int64, epoch int64, validUntil time.Time, lostCh chan struct{}) {
+	ticker := time.NewTicker(p.ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stopCh:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), p.ttl/3)
+			sentAt := time.Now()
+			result, err := p.db.ExecContext(ctx, p.query("UPDATE "+p.table+" SET expires_at = ? WHERE worker_id = ? AND owner = ? AND epoch = ?"),
+				sentAt.Add(p.ttl).UnixMilli(), workerId, p.owner, epoch)
+			var renewed int64
+			if err == nil {
+				renewed, err = result.RowsAffected()
+			}
+			cancel()
+			switch {
+			case err == nil && renewed == 1:
+				validUntil = sentAt.Add(p.ttl)
+				p.validUntil.Store(validUntil)
+				continue
+			case err == nil:
+				log.Printf("worker id %d is claimed by others, lost the worker id", workerId)
+			case time.Now().Before(validUntil):
+				log.Printf("renew worker id %d error %v", workerId, err)
+				continue
+			default:
+				log.Printf("renew worker id %d error %v, the worker id is expired", workerId, err)
+			}
+			close(lostCh)
+			return
+		}
+	}
+}
+
+// query rewrites the ? placeholders to $1, $2... for postgres
+func (p *SQLProvider) query(q string) string {
+	if p.driver != "postgres" && p.driver != "pgx" {
+		return q
+	}
+	var b strings.Builder
+	n := 0
+	for _, c := range q {
+		if c == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+func (p *SQLProvider) Stop() {
+	workerId := p.workerId.Load().(int64)
+	log.Printf("SQLProvider stop, release worker id: %d", workerId)
+	close(p.stopCh)
+	defer p.publish(ProviderStopped, p.workerId.Load().(int64))
+	if p.state.Load() == available {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+		if _, err := p.db.ExecContext(ctx, p.query("UPDATE "+p.table+" SET expires_at = 0 WHERE worker_id = ? AND owner = ? AND epoch = ?"),
+			workerId, p.owner, p.leaseEpoch.Load().(int64)); err != nil {
+			log.Println("release worker id err: ", err)
+		}
+	}
+	if err := p.db.Close(); err != nil {
+		log.Println("close database err: ", err)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSQLProvider(t *testing.T) {
+	// the providers share a sqlite file, an in-memory sqlite is private to each connection
+	dsn := "file:" + filepath.Join(t.TempDir(), "lease.db") + "?_pragma=busy_timeout(5000)"
+	const table = "snowflake_test_lease"
+	open := func() *sql.DB {
+		db, err := sql.Open("sqlite", dsn)
+		if err != nil {
+			t.Fatalf("open sqlite error %v", err)
+		}
+		return db
+	}
+	admin := open()
+	defer admin.Close()
+	if err := createLeaseTable(admin, table); err != nil {
+		t.Fatalf("create lease table error %v", err)
+	}
+
+	testAcquireLoseReacquire(t,
+		func() Provider {
+			return newSQLProvider(open(), "sqlite", table, 300*time.Millisecond, 0, 1, false, false)
+		},
+		func(workerId int64) {
+			// another owner claims the row, the next renewal finds the epoch changed
+			if _, err := admin.Exec("UPDATE "+table+" SET owner = 'other', expires_at = ?, epoch = epoch + 1 WHERE worker_id = ?",
+				time.Now().Add(time.Hour).UnixMilli(), workerId); err != nil {
+				t.Fatalf("claim worker id %d error %v", workerId, err)
+			}
+		},
+		func(workerId int64) {
+			if _, err := admin.Exec("DELETE FROM "+table+" WHERE worker_id = ?", workerId); err != nil {
+				t.Fatalf("delete worker id %d error %v", workerId, err)
+			}
+		},
+	)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// State is persisted in the state file to survive restarts
+type State struct {
+	WorkerId  int64 `json:"worker_id"` // the latest acquired worker id, -1 if none
+	Timestamp int64 `json:"timestamp"` // unix milliseconds no id is issued after
+}
+
+// loadState reads the state file, ok is false if the file does not exist
+func loadState(path string) (state State, ok bool, err error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return State{}, false, nil
+	}
+	if err != nil {
+		return State{}, false, err
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return State{}, false, err
+	}
+	return state, true, nil
+}
+
+// saveState writes the state to a temporary file and renames it, so the state file is never partially written
+func saveState(path string, state State) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// stateKeeper persists the acquired worker id and the timestamp of the Snowflake periodically. The
+// timestamp is written as the later of now and the last timestamp of the Snowflake, which may lead the
+// clock after a borrowed clock rollback, plus the interval plus the max lead of the borrowed timestamps.
+// It is no less than the timestamp of any id issued before the next write, so the state is safe even if
+// the process crashes.
+type stateKeeper struct {
+	path      string
+	interval  time.Duration
+	snowflake *Snowflake
+	workerId  int64
+	stopCh    chan struct{}
+	doneCh    chan struct{}
+}
+
+func newStateKeeper(path string, interval time.Duration, s *Snowflake, p Provider, workerId int64) *stateKeeper {
+	k := &stateKeeper{
+		path:      path,
+		interval:  interval,
+		snowflake: s,
+		workerId:  workerId,
+		stopCh:    make(chan struct{}),
+		doneCh:    make(chan struct{}),
+	}
+	p.Subscribe(func(e ProviderEvent) {
+		if e.Type == ProviderAcquired {
+			atomic.StoreInt64(&k.workerId, e.WorkerId)
+		}
+	})
+	go k.run()
+	return k
+}
+
+func (k *stateKeeper) run() {
+	defer close(k.doneCh)
+	ticker := time.NewTicker(k.interval)
+	defer ticker.Stop()
+	for {
+		timestamp := time.Now().UnixMilli()
+		if last := k.snowflake.LastTimestamp(); last > timestamp {
+			timestamp = last
+		}
+		k.save(timestamp + (k.interval + k.snowflake.MaxLead()).Milliseconds())
+		select {
+		case <-k.stopCh:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (k *stateKeeper) save(timestamp int64) {
+	state := State{WorkerId: atomic.LoadInt64(&k.workerId), Timestamp: timestamp}
+	if err := saveState(k.path, state); err != nil {
+		log.Printf("save state file %s error %v", k.path, err)
+	}
+}
+
+// Stop writes the exact timestamp of the last issued id, it must be called after no id is issued any more
+func (k *stateKeeper) Stop() {
+	close(k.stopCh)
+	<-k.doneCh
+	k.save(k.snowflake.LastTimestamp())
+	log.Printf("save state file %s, worker id: %d", k.path, atomic.LoadInt64(&k.workerId))
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var statefulSetProvider *StatefulSetProvider
+
+// StatefulSetProvider uses the ordinal of the kubernetes StatefulSet pod plus a base as the worker id,
+// the pod name is read from the POD_NAME env var and falls back to the hostname.
+type StatefulSetProvider struct {
+	eventBus
+	workerId int64
+}
+
+func (p *StatefulSetProvider) Stop() {
+	p.publish(ProviderStopped, p.workerId)
+}
+
+func getStatefulSetProvider(base int64, maxWorkerId int64) *StatefulSetProvider {
+	once.Do(func() {
+		podName := os.Getenv("POD_NAME")
+		if podName == "" {
+			hostname, err := os.Hostname()
+			if err != nil {
+				log.Fatalf("Get hostname error: %v", err)
+			}
+			podName = hostname
+		}
+		ordinal, err := parseOrdinal(podName)
+		if err != nil {
+			log.Fatalf("Parse StatefulSet ordinal error: %v", err)
+		}
+		workerId := base + ordinal
+		if base < 0 || workerId > maxWorkerId {
+			log.Fatalf("worker id %d of pod %q (ordinal %d + base %d) must between 0 and %d", workerId, podName, ordinal, base, maxWorkerId)
+		}
+		log.Printf("pod %q uses worker id %d (ordinal %d + base %d)", podName, workerId, ordinal, base)
+		statefulSetProvider = &StatefulSetProvider{
+			workerId: workerId,
+		}
+		statefulSetProvider.publish(ProviderAcquired, workerId)
+	})
+	return statefulSetProvider
+}
+
+func (p *StatefulSetProvider) GetWorkerId() (int64, error) {
+	return p.workerId, nil
+}
+
+// parseOrdinal parses the ordinal of a StatefulSet pod name, e.g. "snowflake-3" is 3
+func parseOrdinal(podName string) (int64, error) {
+	i := strings.LastIndex(podName, "-")
+	if i < 0 {
+		return 0, fmt.Errorf("pod name %q is not in the form of <statefulset>-<ordinal>", podName)
+	}
+	ordinal, err := strconv.ParseInt(podName[i+1:], 10, 64)
+	if err != nil || ordinal < 0 {
+		return 0, fmt.Errorf("pod name %q is not in the form of <statefulset>-<ordinal>", podName)
+	}
+	return ordinal, nil
+}
@@ -0,0 +1,25 @@
+package main
+
+import "testing"
+
+func TestParseOrdinal(t *testing.T) {
+	tests := []struct {
+		podName string
+		want    int64
+		wantErr bool
+	}{
+		{"snowflake-0", 0, false},
+		{"snowflake-3", 3, false},
+		{"snowflake-service-12", 12, false},
+		{"snowflake", 0, true},
+		{"snowflake-", 0, true},
+		{"snowflake-abc", 0, true},
+		{"snowflake-1.5", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseOrdinal(tt.podName)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseOrdinal(%q) = %d, %v, want %d, error %v", tt.podName, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+var staticMapProvider *StaticMapProvider
+
+// StaticMapProvider looks up the worker id of the instance in a YAML or JSON file which maps the
+// hostnames, ip addresses or instance tags to the worker ids, e.g.
+//
+//	host-a: 0
+//	10.0.0.2: 1
+//	tag-c: 2
+//
+// The file is reloaded on SIGHUP, a reload which changes the worker id of the instance is refused.
+type StaticMapProvider struct {
+	eventBus
+	workerId    int64
+	path        string
+	keys        []string
+	maxWorkerId int64
+	signalCh    chan os.Signal
+	stopCh      chan struct{}
+}
+
+// getStaticMapProvider loads the mapping file, the instance is looked up by the instance tag if it is
+// not empty, otherwise by the hostname and the ip addresses of the host.
+func getStaticMapProvider(path string, instance string, maxWorkerId int64) *StaticMapProvider {
+	once.Do(func() {
+		keys, err := instanceKeys(instance)
+		if err != nil {
+			log.Fatalf("Get the keys of the instance error: %v", err)
+		}
+		p := &StaticMapProvider{
+			path:        path,
+			keys:        keys,
+			maxWorkerId: maxWorkerId,
+			signalCh:    make(chan os.Signal, 1),
+			stopCh:      make(chan struct{}),
+		}
+		workerId, err := p.load()
+		if err != nil {
+			log.Fatalf("Load static map %s error: %v", path, err)
+		}
+		log.Printf("static map %s maps the instance to worker id %d", path, workerId)
+		p.workerId = workerId
+		p.publish(ProviderAcquired, workerId)
+		signal.Notify(p.signalCh, syscall.SIGHUP)
+		go p.watch()
+		staticMapProvider = p
+	})
+	return staticMapProvider
+}
+
+func (p *StaticMapProvider) GetWorkerId() (int64, error) {
+	return p.workerId, nil
+}
+
+// watch reloads the mapping file on SIGHUP
+func (p *StaticMapProvider) watch() {
+	for {
+		select {
+		case <-p.stopCh:
+			return
+		case <-p.signalCh:
+			if err := p.reload(); err != nil {
+				log.Printf("reload static map %s error %v, keep worker id %d", p.path, err, p.workerId)
+				continue
+			}
+			log.Printf("reload static map %s success, worker id %d", p.path, p.workerId)
+		}
+	}
+}
+
+// reload loads the mapping file again, it is refused if it changes the worker id of the instance
+func (p *StaticMapProvider) reload() error {
+	workerId, err := p.load()
+	if err != nil {
+		return err
+	}
+	if workerId != p.workerId {
+		return fmt.Errorf("reload refused, it changes the worker id from %d to %d", p.workerId, workerId)
+	}
+	return nil
+}
+
+// load reads and validates the mapping file, returns the worker id of the instance
+func (p *StaticMapProvider) load() (int64, error) {
+	data, err := os.ReadFile(p.path)
+	if err != nil {
+		return 0, err
+	}
+	mapping := make(map[string]int64)
+	if err := yaml.Unmarshal(data, &mapping); err != nil {
+		return 0, err
+	}
+	if err := validateStaticMap(mapping, p.maxWorkerId); err != nil {
+		return 0, err
+	}
+	var matched []string
+	workerId := int64(-1)
+	for _, key := range p.keys {
+		id, ok := mapping[key]
+		if !ok {
+			continue
+		}
+		if workerId >= 0 && id != workerId {
+			return 0, fmt.Errorf("the instance is mapped to different worker ids by %s", strings.Join(append(matched, key), ", "))
+		}
+		matched = append(matched, key)
+		workerId = id
+	}
+	if workerId < 0 {
+		return 0, fmt.Errorf("none of %s is in the static map", strings.Join(p.keys, ", "))
+	}
+	return workerId, nil
+}
+
+// validateStaticMap checks the worker ids are in range and not mapped more than once
+func validateStaticMap(mapping map[string]int64, maxWorkerId int64) error {
+	keys := make([]string, 0, len(mapping))
+	for key := range mapping {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	used := make(map[int64]string)
+	for _, key := range keys {
+		id := mapping[key]
+		if id < 0 || id > maxWorkerId {
+			return fmt.Errorf("worker id of %q must between 0 and %d", key, maxWorkerId)
+		}
+		if other, ok := used[id]; ok {
+			return fmt.Errorf("%q and %q are mapped to the same worker id %d", other, key, id)
+		}
+		used[id] = key
+	}
+	return nil
+}
+
+// instanceKeys returns the keys to look up the instance, the instance tag if it is not empty,
+// otherwise the hostname and the ip addresses of the host.
+func instanceKeys(instance string) ([]string, error) {
+	if instance != "" {
+		return []string{instance}, nil
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	keys := []string{hostname}
+	addrs, err := candidateAddrs("")
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range addrs {
+		keys = append(keys, addr.IP.String())
+	}
+	return keys, nil
+}
+
+func (p *StaticMapProvider) Stop() {
+	signal.Stop(p.signalCh)
+	close(p.stopCh)
+	p.publish(ProviderStopped, p.workerId)
+}
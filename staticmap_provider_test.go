@@ -0,0 +1,77 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidateStaticMap(t *testing.T) {
+	tests := []struct {
+		mapping map[string]int64
+		wantErr bool
+	}{
+		{map[string]int64{}, false},
+		{map[string]int64{"host-a": 0, "10.0.0.2": 1, "tag-c": 255}, false},
+		{map[string]int64{"host-a": -1}, true},
+		{map[string]int64{"host-a": 256}, true},
+		{map[string]int64{"host-a": 1, "10.0.0.2": 1}, true},
+	}
+	for _, tt := range tests {
+		if err := validateStaticMap(tt.mapping, 255); (err != nil) != tt.wantErr {
+			t.Errorf("validateStaticMap(%v) = %v, want error %v", tt.mapping, err, tt.wantErr)
+		}
+	}
+}
+
+func TestStaticMapLoad(t *testing.T) {
+	tests := []struct {
+		content string
+		want    int64
+		wantErr bool
+	}{
+		{"host-a: 3\nhost-b: 4\n", 3, false},
+		{"host-b: 4\n10.0.0.2: 5\n", 5, false},
+		{`{"host-a": 3, "host-b": 4}`, 3, false},
+		{"host-a: 3\n10.0.0.2: 5\n", 0, true},
+		{"host-b: 4\n", 0, true},
+		{"host-a: 3\nhost-b: 3\n", 0, true},
+		{"host-a: [3]\n", 0, true},
+	}
+	path := filepath.Join(t.TempDir(), "static-map.yaml")
+	p := &StaticMapProvider{path: path, keys: []string{"host-a", "10.0.0.2"}, maxWorkerId: 255}
+	for _, tt := range tests {
+		if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+			t.Fatalf("write static map error %v", err)
+		}
+		got, err := p.load()
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("load(%q) = %d, %v, want %d, error %v", tt.content, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestStaticMapReload(t *testing.T) {
+	tests := []struct {
+		content string
+		wantErr bool
+	}{
+		{"host-a: 3\nhost-b: 4\n", false},
+		{"host-a: 4\nhost-b: 3\n", true},
+		{"host-b: 4\n", true},
+		{"host-a: 3\n", false},
+	}
+	path := filepath.Join(t.TempDir(), "static-map.yaml")
+	p := &StaticMapProvider{workerId: 3, path: path, keys: []string{"host-a"}, maxWorkerId: 255}
+	for _, tt := range tests {
+		if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+			t.Fatalf("write static map error %v", err)
+		}
+		if err := p.reload(); (err != nil) != tt.wantErr {
+			t.Errorf("reload(%q) = %v, want error %v", tt.content, err, tt.wantErr)
+		}
+		if p.workerId != 3 {
+			t.Fatalf("reload(%q) changed the worker id to %d", tt.content, p.workerId)
+		}
+	}
+}